@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Claims are the claims of an access token
+type Claims struct {
+	Authorized bool     `json:"authorized"`
+	UserID     uint32   `json:"user_id"`
+	Role       string   `json:"role,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	TokenUse   string   `json:"token_use,omitempty"`
+	// Hash of the CSRF token handed out with a browser session
+	CSRF string `json:"csrf,omitempty"`
+	// Set when the claims were resolved from a personal access token
+	PATID uint64 `json:"pat_id,omitempty"`
+	// The admin acting as UserID while impersonating (RFC 8693 act claim)
+	Act *Actor `json:"act,omitempty"`
+	jwt.StandardClaims
+}
+
+// Actor is the party actually behind a token issued for someone else
+type Actor struct {
+	UserID uint32 `json:"user_id"`
+}
+
+func (c *Claims) Valid() error {
+	err := c.StandardClaims.Valid()
+	if err != nil {
+		return err
+	}
+
+	// Tokens issued before token_use was introduced are access tokens
+	if c.TokenUse != "" && c.TokenUse != TokenUseAccess {
+		return jwt.NewValidationError("not an access token", jwt.ValidationErrorClaimsInvalid)
+	}
+	return nil
+}
+
+func (c *Claims) IssuedAtTime() time.Time {
+	return time.Unix(c.IssuedAt, 0)
+}
+
+func (c *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// GrantedScopes returns the scopes of the token. Tokens without a scopes
+// claim predate scopes and get everything their role allows.
+func (c *Claims) GrantedScopes() []string {
+	if c.Scopes == nil {
+		return DefaultScopes(c.Role)
+	}
+	return c.Scopes
+}
+
+func (c *Claims) IsPersonalAccessToken() bool {
+	return c.PATID != 0
+}
+
+func (c *Claims) IsImpersonation() bool {
+	return c.Act != nil
+}
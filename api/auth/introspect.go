@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Introspection is the RFC 7662 description of a token. Inactive tokens
+// only carry active=false.
+type Introspection struct {
+	Active    bool          `json:"active"`
+	Scope     string        `json:"scope,omitempty"`
+	TokenType string        `json:"token_type,omitempty"`
+	Exp       int64         `json:"exp,omitempty"`
+	Iat       int64         `json:"iat,omitempty"`
+	Sub       string        `json:"sub,omitempty"`
+	Jti       string        `json:"jti,omitempty"`
+	Role      string        `json:"role,omitempty"`
+	Act       *Introspected `json:"act,omitempty"`
+}
+
+// Introspected names the party behind an impersonation token
+type Introspected struct {
+	Sub string `json:"sub"`
+}
+
+// Subject formats a user ID as a sub claim
+func Subject(uid uint32) string {
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+// IntrospectAccessToken describes an access token or personal access token
+func IntrospectAccessToken(tokenString string) *Introspection {
+
+	claims, err := ParseAccessToken(tokenString)
+	if err != nil {
+		return &Introspection{Active: false}
+	}
+
+	in := &Introspection{
+		Active:    true,
+		Scope:     strings.Join(claims.GrantedScopes(), " "),
+		TokenType: "access_token",
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Sub:       Subject(claims.UserID),
+		Jti:       claims.Id,
+		Role:      claims.Role,
+	}
+	if claims.IsImpersonation() {
+		in.Act = &Introspected{Sub: Subject(claims.Act.UserID)}
+	}
+	return in
+}
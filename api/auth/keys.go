@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey is one entry of the key set, identified by the kid header
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+	Retired bool
+}
+
+// KeyManager signs tokens with the active key and verifies them against any
+// key that has not been retired, so keys can be rotated without logging
+// everyone out.
+type KeyManager struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active string
+}
+
+// JWK is a public key as published in the JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keyManager   *KeyManager
+	keyManagerMu sync.Mutex
+)
+
+// SetKeyManager registers the key set used by CreateToken and TokenParser
+func SetKeyManager(km *KeyManager) {
+	keyManagerMu.Lock()
+	defer keyManagerMu.Unlock()
+	keyManager = km
+}
+
+// Keys returns the registered key set, falling back to the legacy
+// API_SECRET HMAC key when none was loaded.
+func Keys() *KeyManager {
+	keyManagerMu.Lock()
+	defer keyManagerMu.Unlock()
+	if keyManager == nil {
+		keyManager = NewHMACKeyManager(os.Getenv("API_SECRET"))
+	}
+	return keyManager
+}
+
+// NewKeyManager builds a key set signing with the first key
+func NewKeyManager(keys ...*SigningKey) *KeyManager {
+	km := &KeyManager{keys: map[string]*SigningKey{}}
+	for _, key := range keys {
+		km.Add(key)
+	}
+	if len(keys) > 0 {
+		km.active = keys[0].ID
+	}
+	return km
+}
+
+func NewHMACKeyManager(secret string) *KeyManager {
+	return NewKeyManager(&SigningKey{
+		ID:      "hmac",
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	})
+}
+
+// LoadKeyManager reads every <kid>.pem private key from JWT_KEYS_DIR. The
+// signing key is JWT_ACTIVE_KID (or the last kid in lexical order) and the
+// kids in JWT_RETIRED_KIDS are no longer accepted.
+func LoadKeyManager() (*KeyManager, error) {
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR not set, signing tokens with API_SECRET")
+		return NewHMACKeyManager(os.Getenv("API_SECRET")), nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	retired := map[string]bool{}
+	for _, kid := range strings.Split(os.Getenv("JWT_RETIRED_KIDS"), ",") {
+		if kid = strings.TrimSpace(kid); kid != "" {
+			retired[kid] = true
+		}
+	}
+
+	km := &KeyManager{keys: map[string]*SigningKey{}}
+	kids := []string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := ParseSigningKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		key.Retired = retired[kid]
+		km.Add(key)
+		if !key.Retired {
+			kids = append(kids, kid)
+		}
+	}
+
+	if len(kids) == 0 {
+		return nil, errors.New("no usable signing keys in " + dir)
+	}
+
+	sort.Strings(kids)
+	active := os.Getenv("JWT_ACTIVE_KID")
+	if active == "" {
+		active = kids[len(kids)-1]
+	}
+	if err := km.SetActive(active); err != nil {
+		return nil, err
+	}
+
+	return km, nil
+}
+
+// ParseSigningKey decodes a PEM encoded RSA or Ed25519 private key
+func ParseSigningKey(kid string, data []byte) (*SigningKey, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var private interface{}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.New("unsupported private key")
+		}
+	}
+
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: SigningMethodEd25519, Private: key, Public: key.Public()}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", private)
+}
+
+func (km *KeyManager) Add(key *SigningKey) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.keys[key.ID] = key
+}
+
+func (km *KeyManager) SetActive(kid string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	key, ok := km.keys[kid]
+	if !ok || key.Retired {
+		return fmt.Errorf("signing key %q is not available", kid)
+	}
+	km.active = kid
+	return nil
+}
+
+// Retire stops accepting tokens signed with kid
+func (km *KeyManager) Retire(kid string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	key, ok := km.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", kid)
+	}
+	if kid == km.active {
+		return errors.New("cannot retire the active signing key")
+	}
+	key.Retired = true
+	return nil
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	km.mu.RLock()
+	key := km.keys[km.active]
+	km.mu.RUnlock()
+
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key from the kid header
+func (km *KeyManager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		// Tokens issued before kid headers were added
+		kid = "hmac"
+	}
+
+	key, ok := km.keys[kid]
+	if !ok || key.Retired {
+		return nil, fmt.Errorf("unknown signing key: %v", t.Header["kid"])
+	}
+
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// JWKS returns the public part of every non-retired asymmetric key
+func (km *KeyManager) JWKS() JWKSet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range km.keys {
+		if key.Retired {
+			continue
+		}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// PublicKey decodes the JWK into a key usable to verify tokens
+func (k JWK) PublicKey() (interface{}, jwt.SigningMethod, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, nil, err
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return public, jwt.SigningMethodRS256, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), SigningMethodEd25519, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
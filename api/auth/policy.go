@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles are hierarchical, a role grants everything the lower ones do
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID uint32
+	Role   string
+	Scopes []string
+	// ActorID is the admin behind an impersonation token, 0 otherwise
+	ActorID uint32
+}
+
+func ExtractPrincipal(r *http.Request) (Principal, error) {
+
+	claims, err := ExtractClaims(r)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	role := claims.Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	principal := Principal{UserID: claims.UserID, Role: role, Scopes: claims.GrantedScopes()}
+	if claims.IsImpersonation() {
+		principal.ActorID = claims.Act.UserID
+	}
+	return principal, nil
+}
+
+func (p Principal) HasRole(role string) bool {
+	return roleRank[p.Role] >= roleRank[role]
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return HasScope(p.Scopes, scope)
+}
+
+// CanManageUser allows users to manage their own account and admins any account
+func (p Principal) CanManageUser(userID uint32) bool {
+	return p.UserID == userID || p.HasRole(RoleAdmin)
+}
+
+// CanModerate allows owners to change their content and moderators anyone's
+func (p Principal) CanModerate(ownerID uint32) bool {
+	return p.UserID == ownerID || p.HasRole(RoleModerator)
+}
+
+func (p Principal) IsImpersonating() bool {
+	return p.ActorID != 0
+}
+
+func (p Principal) String() string {
+	if p.IsImpersonating() {
+		return fmt.Sprintf("user %d (%s) impersonated by user %d", p.UserID, p.Role, p.ActorID)
+	}
+	return fmt.Sprintf("user %d (%s)", p.UserID, p.Role)
+}
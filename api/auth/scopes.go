@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Scopes restrict what a token may be used for. A read-only token carries
+// no write scope and is therefore rejected by every route that requires one.
+const (
+	ScopeReadOnly   = "read-only"
+	ScopePostsWrite = "posts:write"
+	ScopeUsersWrite = "users:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+var ErrScopeNotAllowed = errors.New("scope not allowed")
+
+// The scopes each role may hold, also what a login grants by default
+var roleScopes = map[string][]string{
+	RoleUser:      {ScopePostsWrite, ScopeUsersWrite},
+	RoleModerator: {ScopePostsWrite, ScopeUsersWrite},
+	RoleAdmin:     {ScopePostsWrite, ScopeUsersWrite, ScopeUsersAdmin},
+}
+
+func IsValidScope(scope string) bool {
+	if scope == ScopeReadOnly {
+		return true
+	}
+	for _, s := range roleScopes[RoleAdmin] {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func DefaultScopes(role string) []string {
+	scopes, ok := roleScopes[role]
+	if !ok {
+		scopes = roleScopes[RoleUser]
+	}
+	return append([]string{}, scopes...)
+}
+
+// RestrictScopes validates the requested scopes against what the role may
+// hold. Requesting no scopes grants the role defaults.
+func RestrictScopes(role string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return DefaultScopes(role), nil
+	}
+
+	allowed := map[string]bool{ScopeReadOnly: true}
+	for _, scope := range DefaultScopes(role) {
+		allowed[scope] = true
+	}
+
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		if !allowed[scope] {
+			return nil, fmt.Errorf("%w: %s", ErrScopeNotAllowed, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// FilterScopes drops the scopes the role may no longer hold, e.g. after a
+// refresh following a role change.
+func FilterScopes(role string, scopes []string) []string {
+	if len(scopes) == 0 {
+		return DefaultScopes(role)
+	}
+
+	filtered := []string{}
+	for _, scope := range scopes {
+		if scope == ScopeReadOnly || HasScope(DefaultScopes(role), scope) {
+			filtered = append(filtered, scope)
+		}
+	}
+	if len(filtered) == 0 {
+		filtered = append(filtered, ScopeReadOnly)
+	}
+	return filtered
+}
+
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -2,17 +2,58 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+const (
+	AccessTokenTTL  = time.Hour * 1
+	RefreshTokenTTL = time.Hour * 24 * 30
+	// Impersonation tokens are short lived and cannot be refreshed
+	ImpersonationTTL = time.Minute * 15
+)
+
+// token_use claim values, only access tokens authenticate API requests
+const (
+	TokenUseAccess      = "access"
+	TokenUseVerifyEmail = "verify_email"
+	TokenUseMFAPending  = "mfa_pending"
+	TokenUseOIDCState   = "oidc_state"
+	TokenUseMagicLink   = "magic_link"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Denylist reports whether an otherwise valid access token was revoked
+// before its expiry, e.g. on logout or because all sessions of the user
+// were invalidated.
+type Denylist interface {
+	IsRevoked(jti string, userID uint32, issuedAt time.Time) (bool, error)
+}
+
+var denylist Denylist
+
+// SetDenylist registers the store consulted by ValidateToken and ExtractTokenID.
+func SetDenylist(d Denylist) {
+	denylist = d
+}
+
+// TokenPair is returned on login and refresh. In session mode the tokens
+// are moved into cookies and only the CSRF token is returned.
+type TokenPair struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
 // Pretty display the claims licely in the terminal
 func Pretty(data interface{}) {
 
@@ -25,15 +66,63 @@ func Pretty(data interface{}) {
 	fmt.Println(string(res))
 }
 
-func CreateToken(userId uint32) (string, error) {
+// CreateToken signs an access token. csrfHash binds the token to the CSRF
+// token of a browser session and may be empty, client is handed to the
+// session tracker.
+func CreateToken(userId uint32, role string, scopes []string, csrfHash string, client ClientInfo) (string, error) {
+
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		Authorized: true,
+		UserID:     userId,
+		Role:       role,
+		Scopes:     scopes,
+		TokenUse:   TokenUseAccess,
+		CSRF:       csrfHash,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(), // Token expires after 1 hour
+		},
+	}
 
-	claims := jwt.MapClaims{}
-	claims["authorized"] = true
-	claims["user_id"] = userId
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix() // Token expires after 1 hour
+	token, err := Keys().Sign(claims)
+	if err != nil {
+		return "", err
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	err = trackToken(claims, client)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CreateImpersonationToken issues an access token for userID carrying the
+// admin behind it in the act claim. The jti ties it to the audit log.
+func CreateImpersonationToken(jti string, actorID, userID uint32, role string, scopes []string) (string, error) {
+
+	now := time.Now()
+	claims := &Claims{
+		Authorized: true,
+		UserID:     userID,
+		Role:       role,
+		Scopes:     scopes,
+		TokenUse:   TokenUseAccess,
+		Act:        &Actor{UserID: actorID},
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ImpersonationTTL).Unix(),
+		},
+	}
+
+	return Keys().Sign(claims)
 }
 
 func ExtractToken(r *http.Request) string {
@@ -49,51 +138,104 @@ func ExtractToken(r *http.Request) string {
 		return strings.Split(bearerToken, " ")[1]
 	}
 
+	// Browser sessions keep the token in an HttpOnly cookie
+	cookie, err := r.Cookie(AccessTokenCookie)
+	if err == nil {
+		return cookie.Value
+	}
+
 	return ""
 }
 
 func TokenParser(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(
-		tokenString,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	return jwt.Parse(tokenString, Keys().Keyfunc)
+}
+
+// ExtractClaims parses the request token and checks it against the denylist.
+// Personal access tokens are resolved through the registered store instead.
+func ExtractClaims(r *http.Request) (*Claims, error) {
+	return ParseAccessToken(ExtractToken(r))
+}
+
+// ParseAccessToken verifies an access token or personal access token and
+// checks it was not revoked
+func ParseAccessToken(tokenString string) (*Claims, error) {
+
+	if IsPersonalAccessToken(tokenString) {
+		return personalAccessTokenClaims(tokenString)
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys().Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if denylist != nil {
+		revoked, err := denylist.IsRevoked(claims.Id, claims.UserID, claims.IssuedAtTime())
+		if err != nil {
+			return nil, err
+		}
+		// Revoking the admin's sessions also ends their impersonations
+		if !revoked && claims.IsImpersonation() {
+			revoked, err = denylist.IsRevoked("", claims.Act.UserID, claims.IssuedAtTime())
+			if err != nil {
+				return nil, err
 			}
-			return []byte(os.Getenv("API_SECRET")), nil
-		},
-	)
-	return token, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
 }
 
 func ValidateToken(r *http.Request) error {
 
-	tokenString := ExtractToken(r)
-	token, err := TokenParser(tokenString)
+	claims, err := ExtractClaims(r)
 	if err != nil {
 		return err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
-	}
+	Pretty(claims)
 	return nil
 }
 
+// ExtractTokenID returns the user the token acts as, which is the
+// impersonated user for impersonation tokens
 func ExtractTokenID(r *http.Request) (uint32, error) {
 
-	tokenString := ExtractToken(r)
-	token, err := TokenParser(tokenString)
+	claims, err := ExtractClaims(r)
 	if err != nil {
 		return 0, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
-		if err != nil {
-			return 0, err
-		}
-		return uint32(uid), nil
+	return claims.UserID, nil
+}
+
+// CreatePurposeToken signs a short-lived token that can only be used for
+// the given purpose, e.g. an email verification link.
+func CreatePurposeToken(use string, ttl time.Duration, claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+	claims["token_use"] = use
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	return Keys().Sign(claims)
+}
+
+func ParsePurposeToken(tokenString, use string) (jwt.MapClaims, error) {
+	token, err := TokenParser(tokenString)
+	if err != nil {
+		return nil, err
 	}
 
-	return 0, nil
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["token_use"] != use {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
 }
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"sync"
+)
+
+// ClientInfo describes who an access token is issued to
+type ClientInfo struct {
+	// SessionID groups the tokens of one login, refreshing keeps it
+	SessionID string
+	UserAgent string
+	IP        string
+}
+
+// SessionTracker records issued access tokens so users can see where they
+// are logged in and end those sessions
+type SessionTracker interface {
+	TrackToken(claims *Claims, client ClientInfo) error
+	// TouchToken records that the token was just used
+	TouchToken(claims *Claims) error
+}
+
+var (
+	sessionTracker   SessionTracker
+	sessionTrackerMu sync.RWMutex
+)
+
+func SetSessionTracker(t SessionTracker) {
+	sessionTrackerMu.Lock()
+	defer sessionTrackerMu.Unlock()
+	sessionTracker = t
+}
+
+func trackToken(claims *Claims, client ClientInfo) error {
+	sessionTrackerMu.RLock()
+	defer sessionTrackerMu.RUnlock()
+	if sessionTracker == nil {
+		return nil
+	}
+	return sessionTracker.TrackToken(claims, client)
+}
+
+// TouchSession updates the last seen time of the session behind the token.
+// Personal access and impersonation tokens are not sessions.
+func TouchSession(claims *Claims) error {
+	sessionTrackerMu.RLock()
+	defer sessionTrackerMu.RUnlock()
+	if sessionTracker == nil || claims.IsPersonalAccessToken() || claims.IsImpersonation() {
+		return nil
+	}
+	return sessionTracker.TouchToken(claims)
+}
@@ -0,0 +1,440 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+const (
+	defaultAttachmentMaxBytes = 10 << 20
+	defaultAvatarMaxBytes     = 2 << 20
+	// downloadURLTTL is how long signed download links work
+	downloadURLTTL = 15 * time.Minute
+)
+
+// The type of an upload is sniffed from its first bytes, whatever the client
+// claims. These are the accepted ones and the extension they are stored with.
+var (
+	imageTypes = map[string]string{
+		"image/png":  ".png",
+		"image/jpeg": ".jpg",
+		"image/gif":  ".gif",
+		"image/webp": ".webp",
+	}
+	attachmentTypes = map[string]string{
+		"image/png":                 ".png",
+		"image/jpeg":                ".jpg",
+		"image/gif":                 ".gif",
+		"image/webp":                ".webp",
+		"application/pdf":           ".pdf",
+		"application/zip":           ".zip",
+		"text/plain; charset=utf-8": ".txt",
+	}
+)
+
+// upload is a file read from a multipart request
+type upload struct {
+	Data        []byte
+	Filename    string
+	ContentType string
+	Ext         string
+}
+
+// maxUploadBytes reads a size limit from the environment, ATTACHMENT_MAX_BYTES
+// and AVATAR_MAX_BYTES
+func maxUploadBytes(key string, fallback int64) int64 {
+	max, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || max <= 0 {
+		return fallback
+	}
+	return max
+}
+
+// readUpload reads the "file" part of a multipart/form-data body, up to max
+// bytes and of one of the allowed types
+func readUpload(w http.ResponseWriter, r *http.Request, max int64, allowed map[string]string) (*upload, bool) {
+
+	// Room for the multipart framing and other small fields
+	r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("expected a multipart/form-data body"))
+		return nil, false
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("required file"))
+			return nil, false
+		}
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusBadRequest, err)
+			return nil, false
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, max+1))
+		var tooLarge *http.MaxBytesError
+		if int64(len(data)) > max || errors.As(err, &tooLarge) {
+			responses.ErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file must be at most %d bytes", max))
+			return nil, false
+		}
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusBadRequest, err)
+			return nil, false
+		}
+		if len(data) == 0 {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("empty file"))
+			return nil, false
+		}
+
+		contentType := http.DetectContentType(data)
+		ext, ok := allowed[contentType]
+		if !ok {
+			responses.ErrorResponse(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported file type %s", contentType))
+			return nil, false
+		}
+
+		filename := truncate(strings.TrimSpace(filepath.Base(strings.ReplaceAll(part.FileName(), `\`, "/"))), 255)
+		if filename == "" || filename == "." || filename == "/" {
+			filename = "file" + ext
+		}
+		return &upload{Data: data, Filename: filename, ContentType: contentType, Ext: ext}, true
+	}
+}
+
+// storeUpload puts the upload under prefix with a random name and returns
+// its key
+func (s *Server) storeUpload(u *upload, prefix string) (string, error) {
+	name, err := auth.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	key := prefix + "/" + name + u.Ext
+	err = s.Storage.Put(key, bytes.NewReader(u.Data), int64(len(u.Data)), u.ContentType)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// deleteStored removes a file no longer referenced, a failure only leaves
+// an orphan behind
+func (s *Server) deleteStored(key string) {
+	err := s.Storage.Delete(key)
+	if err != nil {
+		log.Printf("cannot delete stored file %s: %v", key, err)
+	}
+}
+
+// signAttachment fills in the download link of the attachment
+func (s *Server) signAttachment(a *models.Attachment) error {
+	url, err := s.Storage.SignedURL(a.Key, time.Now().Add(downloadURLTTL))
+	if err != nil {
+		return err
+	}
+	a.URL = url
+	return nil
+}
+
+// attachmentPost loads the {id} post, which must be visible to the caller
+func (s *Server) attachmentPost(w http.ResponseWriter, r *http.Request) (*models.Post, bool) {
+
+	pid, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	post := models.Post{}
+	err = s.DB.Model(&models.Post{}).Where("id = ?", pid).Take(&post).Error
+	if err != nil || !canViewPost(r, &post) {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("post not found"))
+		return nil, false
+	}
+	return &post, true
+}
+
+// findAttachment loads the {aid} attachment of the post
+func (s *Server) findAttachment(w http.ResponseWriter, r *http.Request, pid uint64) (*models.Attachment, bool) {
+
+	aid, err := strconv.ParseUint(mux.Vars(r)["aid"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	attachment, err := models.FindAttachment(s.DB, pid, aid)
+	if errors.Is(err, models.ErrAttachmentNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return attachment, true
+}
+
+// UploadAttachment takes the file in the "file" field of a multipart body
+func (s *Server) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+
+	post, ok := s.attachmentPost(w, r)
+	if !ok {
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if !principal.CanModerate(post.AuthorID) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	u, ok := readUpload(w, r, maxUploadBytes("ATTACHMENT_MAX_BYTES", defaultAttachmentMaxBytes), attachmentTypes)
+	if !ok {
+		return
+	}
+
+	key, err := s.storeUpload(u, fmt.Sprintf("attachments/%d", post.ID))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	attachment := models.Attachment{
+		PostID:      post.ID,
+		UploaderID:  principal.UserID,
+		Key:         key,
+		Filename:    u.Filename,
+		ContentType: u.ContentType,
+		Size:        int64(len(u.Data)),
+		CreatedAt:   time.Now(),
+	}
+	attachmentCreated, err := attachment.SaveAttachment(s.DB)
+	if err != nil {
+		s.deleteStored(key)
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = s.signAttachment(attachmentCreated)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, attachmentCreated.ID))
+	responses.JsonResponse(w, http.StatusCreated, attachmentCreated)
+}
+
+func (s *Server) GetAttachments(w http.ResponseWriter, r *http.Request) {
+
+	post, ok := s.attachmentPost(w, r)
+	if !ok {
+		return
+	}
+
+	attachments, err := models.FindPostAttachments(s.DB, post.ID)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for i := range attachments {
+		err = s.signAttachment(&attachments[i])
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	responses.JsonResponse(w, http.StatusOK, attachments)
+}
+
+func (s *Server) GetAttachment(w http.ResponseWriter, r *http.Request) {
+
+	post, ok := s.attachmentPost(w, r)
+	if !ok {
+		return
+	}
+
+	attachment, ok := s.findAttachment(w, r, post.ID)
+	if !ok {
+		return
+	}
+
+	err := s.signAttachment(attachment)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, attachment)
+}
+
+// DownloadAttachment redirects to a fresh signed link, so posts can embed a
+// link that does not expire
+func (s *Server) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+
+	post, ok := s.attachmentPost(w, r)
+	if !ok {
+		return
+	}
+
+	attachment, ok := s.findAttachment(w, r, post.ID)
+	if !ok {
+		return
+	}
+
+	err := s.signAttachment(attachment)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	http.Redirect(w, r, attachment.URL, http.StatusFound)
+}
+
+func (s *Server) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+
+	post, ok := s.attachmentPost(w, r)
+	if !ok {
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if !principal.CanModerate(post.AuthorID) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	attachment, ok := s.findAttachment(w, r, post.ID)
+	if !ok {
+		return
+	}
+
+	err = models.DeleteAttachment(s.DB, attachment.ID)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.deleteStored(attachment.Key)
+
+	w.Header().Set("Entity", fmt.Sprintf("%d", attachment.ID))
+	responses.JsonResponse(w, http.StatusOK, "")
+}
+
+// UploadAvatar takes an image in the "file" field of a multipart body and
+// replaces the previous avatar
+func (s *Server) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	u, ok := readUpload(w, r, maxUploadBytes("AVATAR_MAX_BYTES", defaultAvatarMaxBytes), imageTypes)
+	if !ok {
+		return
+	}
+
+	key, err := s.storeUpload(u, fmt.Sprintf("avatars/%d", uid))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	user := models.User{}
+	previous, err := user.SetAvatar(s.DB, uid, key)
+	if err != nil {
+		s.deleteStored(key)
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if previous != "" {
+		s.deleteStored(previous)
+	}
+
+	userUpdated, err := user.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, userUpdated)
+}
+
+// GetAvatar redirects to a signed link to the avatar of the user
+func (s *Server) GetAvatar(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uint32(uid))
+	if err != nil || user.AvatarKey == "" {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("avatar not found"))
+		return
+	}
+
+	url, err := s.Storage.SignedURL(user.AvatarKey, time.Now().Add(downloadURLTTL))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (s *Server) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	user := models.User{}
+	previous, err := user.SetAvatar(s.DB, uid, "")
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if previous != "" {
+		s.deleteStored(previous)
+	}
+
+	w.Header().Set("Entity", fmt.Sprintf("%d", uid))
+	responses.JsonResponse(w, http.StatusOK, "")
+}
@@ -6,7 +6,11 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/mailer"
 	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/oidc"
+	"github.com/mvr-garcia/fullgo/api/storage"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -14,6 +18,12 @@ import (
 type Server struct {
 	DB     *gorm.DB
 	Router *mux.Router
+	Mailer mailer.Mailer
+
+	PostSearch models.PostSearchIndex
+	Storage    storage.Storage
+
+	OIDCProviders map[string]*oidc.Provider
 }
 
 func (s *Server) Initialize(DbDriver, DbUser, DbPassword, DbPort, DbHost, DbName string) {
@@ -29,13 +39,59 @@ func (s *Server) Initialize(DbDriver, DbUser, DbPassword, DbPort, DbHost, DbName
 		fmt.Printf("We are connected to the %s database", DbDriver)
 	}
 
-	s.DB.AutoMigrate(&models.User{}, &models.Post{}) // Database migration
+	s.DB.AutoMigrate( // Database migration
+		&models.User{},
+		&models.Post{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.PasswordReset{},
+		&models.RecoveryCode{},
+		&models.LoginAttempt{},
+		&models.PersonalAccessToken{},
+		&models.UserIdentity{},
+		&models.PasswordHistory{},
+		&models.ImpersonationSession{},
+		&models.UserSession{},
+		&models.MagicLink{},
+		&models.Category{},
+		&models.Tag{},
+		&models.Comment{},
+		&models.PostRevision{},
+		&models.PostSlug{},
+		&models.Attachment{},
+	)
+
+	err = models.BackfillPostSlugs(s.DB)
+	if err != nil {
+		log.Printf("cannot give slugs to existing posts: %v", err)
+	}
+
+	auth.SetDenylist(models.Denylist{DB: s.DB})
+	auth.SetPersonalAccessTokenStore(models.PersonalAccessTokens{DB: s.DB})
+	auth.SetSessionTracker(models.SessionTracker{DB: s.DB})
+
+	keys, err := auth.LoadKeyManager()
+	if err != nil {
+		log.Fatal("Cannot load signing keys: ", err)
+	}
+	auth.SetKeyManager(keys)
+
+	s.PostSearch, err = models.NewPostSearchIndex(s.DB)
+	if err != nil {
+		log.Fatal("Cannot set up post search: ", err)
+	}
+
+	s.Mailer = mailer.FromEnv()
+	s.Storage = storage.FromEnv()
 	s.Router = mux.NewRouter()
+	s.initializeOIDC()
 
 	s.InitializeRoutes()
 }
 
 func (s *Server) Run(addr string) {
+	go s.runPostScheduler(schedulerInterval())
+
 	fmt.Println("Listening to port 8080")
 	log.Fatal(http.ListenAndServe(addr, s.Router))
 }
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+	"github.com/mvr-garcia/fullgo/api/utils"
+)
+
+// GetCategories returns the whole category tree
+func (s *Server) GetCategories(w http.ResponseWriter, r *http.Request) {
+
+	categories, err := models.FindCategoryTree(s.DB)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, categories)
+}
+
+func readCategory(r *http.Request) (models.Category, error) {
+
+	category := models.Category{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return category, err
+	}
+
+	err = json.Unmarshal(body, &category)
+	if err != nil {
+		return category, err
+	}
+
+	category.Prepare()
+	return category, category.Validate()
+}
+
+// CreateCategory is only routed for admins
+func (s *Server) CreateCategory(w http.ResponseWriter, r *http.Request) {
+
+	category, err := readCategory(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	categoryCreated, err := category.SaveCategory(s.DB)
+	if errors.Is(err, models.ErrCategoryNotFound) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("parent category not found"))
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, utils.FormatError(err.Error()))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, categoryCreated.ID))
+	responses.JsonResponse(w, http.StatusCreated, categoryCreated)
+}
+
+// UpdateCategory is only routed for admins, it renames and moves categories
+func (s *Server) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	cid, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, err = models.FindCategoryByID(s.DB, cid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+
+	category, err := readCategory(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	categoryUpdated, err := category.UpdateACategory(s.DB, cid)
+	if errors.Is(err, models.ErrCategoryNotFound) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("parent category not found"))
+		return
+	}
+	if errors.Is(err, models.ErrCategoryCycle) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, utils.FormatError(err.Error()))
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, categoryUpdated)
+}
+
+// DeleteCategory is only routed for admins
+func (s *Server) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	cid, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = models.DeleteACategory(s.DB, cid)
+	if errors.Is(err, models.ErrCategoryNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Entity", fmt.Sprintf("%d", cid))
+	responses.JsonResponse(w, http.StatusNoContent, "")
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+// commentPost reads {id} and makes sure the post exists and can be seen by
+// the caller
+func (s *Server) commentPost(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+
+	pid, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return 0, false
+	}
+
+	post := models.Post{}
+	err = s.DB.Model(&models.Post{}).Where("id = ?", pid).Take(&post).Error
+	if err != nil || !canViewPost(r, &post) {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("post not found"))
+		return 0, false
+	}
+	return pid, true
+}
+
+// findComment loads the {cid} comment of the post
+func (s *Server) findComment(w http.ResponseWriter, r *http.Request, pid uint64) (*models.Comment, bool) {
+
+	cid, err := strconv.ParseUint(mux.Vars(r)["cid"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	comment, err := models.FindCommentByID(s.DB, pid, cid)
+	if errors.Is(err, models.ErrCommentNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return comment, true
+}
+
+// parseDepth reads ?depth=, how many levels of replies to include
+func parseDepth(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("depth")
+	if v == "" {
+		return models.DefaultCommentDepth, nil
+	}
+
+	depth, err := strconv.Atoi(v)
+	if err != nil || depth < 0 {
+		return 0, errors.New("invalid depth")
+	}
+	if depth > models.MaxCommentDepth {
+		depth = models.MaxCommentDepth
+	}
+	return depth, nil
+}
+
+func (s *Server) CreateComment(w http.ResponseWriter, r *http.Request) {
+
+	pid, ok := s.commentPost(w, r)
+	if !ok {
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	comment := models.Comment{}
+	err = json.Unmarshal(body, &comment)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// Comments are always written as the authenticated user
+	comment.Prepare()
+	comment.PostID = pid
+	comment.AuthorID = principal.UserID
+	err = comment.Validate()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	author := models.User{}
+	_, err = author.FindUserByID(s.DB, principal.UserID)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("author not found"))
+		return
+	}
+
+	if author.EmailVerifiedAt == nil {
+		responses.ErrorResponse(w, http.StatusForbidden, errors.New("email not verified"))
+		return
+	}
+
+	commentCreated, err := comment.SaveComment(s.DB)
+	if errors.Is(err, models.ErrCommentNotFound) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("parent comment not found"))
+		return
+	}
+	if errors.Is(err, models.ErrCommentTooDeep) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, commentCreated.ID))
+	responses.JsonResponse(w, http.StatusCreated, commentCreated)
+}
+
+// GetComments lists the top level comments of a post with their replies
+// nested up to ?depth= levels, deeper threads are read through GetComment
+func (s *Server) GetComments(w http.ResponseWriter, r *http.Request) {
+
+	pid, ok := s.commentPost(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parsePageQuery(r, models.CommentSorts)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	depth, err := parseDepth(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	comments, next, err := models.FindPostComments(s.DB, pid, page, depth)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writePage(w, r, comments, next)
+}
+
+func (s *Server) GetComment(w http.ResponseWriter, r *http.Request) {
+
+	pid, ok := s.commentPost(w, r)
+	if !ok {
+		return
+	}
+
+	comment, ok := s.findComment(w, r, pid)
+	if !ok {
+		return
+	}
+
+	depth, err := parseDepth(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tree, err := models.FindCommentTree(s.DB, pid, comment.ID, depth)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, tree)
+}
+
+func (s *Server) UpdateComment(w http.ResponseWriter, r *http.Request) {
+
+	pid, ok := s.commentPost(w, r)
+	if !ok {
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	comment, ok := s.findComment(w, r, pid)
+	if !ok {
+		return
+	}
+
+	if !principal.CanModerate(comment.AuthorID) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	commentUpdate := models.Comment{}
+	err = json.Unmarshal(body, &commentUpdate)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// Only the content can change, replies stay where they are
+	commentUpdate.Prepare()
+	commentUpdate.ID = comment.ID
+	commentUpdate.PostID = pid
+	commentUpdate.AuthorID = comment.AuthorID
+	err = commentUpdate.Validate()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	commentUpdated, err := commentUpdate.UpdateAComment(s.DB)
+	if errors.Is(err, models.ErrCommentNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, commentUpdated)
+}
+
+func (s *Server) DeleteComment(w http.ResponseWriter, r *http.Request) {
+
+	pid, ok := s.commentPost(w, r)
+	if !ok {
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	comment, ok := s.findComment(w, r, pid)
+	if !ok {
+		return
+	}
+
+	// Is the authenticated user the owner of this comment or a moderator?
+	if !principal.CanModerate(comment.AuthorID) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	err = models.DeleteAComment(s.DB, comment)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Entity", fmt.Sprintf("%d", comment.ID))
+	responses.JsonResponse(w, http.StatusOK, "")
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+	"github.com/mvr-garcia/fullgo/api/utils"
+)
+
+type impersonationResponse struct {
+	AccessToken string                       `json:"access_token"`
+	TokenType   string                       `json:"token_type"`
+	ExpiresIn   int64                        `json:"expires_in"`
+	Session     *models.ImpersonationSession `json:"session"`
+}
+
+// Impersonate is only routed for admins. It issues a short lived access
+// token for the user in the path, without a refresh token, and records the
+// session in the audit log.
+func (s *Server) Impersonate(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	uid, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		Reason string `json:"reason"`
+	}{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	if uint32(uid) == principal.UserID {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("cannot impersonate yourself"))
+		return
+	}
+
+	user := models.User{}
+	target, err := user.FindUserByID(s.DB, uint32(uid))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+
+	// Acting as another admin would hand out their privileges
+	if target.Role == auth.RoleAdmin {
+		responses.ErrorResponse(w, http.StatusForbidden, errors.New("cannot impersonate an admin"))
+		return
+	}
+
+	jti, err := auth.RandomToken(16)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session := models.ImpersonationSession{
+		AdminID:   principal.UserID,
+		TargetID:  target.ID,
+		JTI:       jti,
+		Reason:    truncate(strings.TrimSpace(req.Reason), 255),
+		IPAddress: utils.ClientIP(r),
+		UserAgent: truncate(r.UserAgent(), 255),
+		ExpiresAt: time.Now().Add(auth.ImpersonationTTL),
+	}
+	_, err = session.SaveImpersonationSession(s.DB)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	token, err := auth.CreateImpersonationToken(jti, principal.UserID, target.ID, target.Role, auth.DefaultScopes(target.Role))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("impersonation %d: admin %d acting as user %d", session.ID, session.AdminID, session.TargetID)
+
+	responses.JsonResponse(w, http.StatusCreated, impersonationResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(auth.ImpersonationTTL.Seconds()),
+		Session:     &session,
+	})
+}
+
+// GetImpersonationSessions is the audit log, filtered by ?admin_id= and
+// ?target_id=
+func (s *Server) GetImpersonationSessions(w http.ResponseWriter, r *http.Request) {
+
+	var adminID, targetID uint64
+	var err error
+	if v := r.URL.Query().Get("admin_id"); v != "" {
+		adminID, err = strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("target_id"); v != "" {
+		targetID, err = strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	sessions, err := models.FindImpersonationSessions(s.DB, uint32(adminID), uint32(targetID))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, sessions)
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
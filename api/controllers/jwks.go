@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+// JWKS publishes the public signing keys so other services can verify our tokens
+func (s *Server) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	responses.JsonResponse(w, http.StatusOK, auth.Keys().JWKS())
+}
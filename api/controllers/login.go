@@ -2,30 +2,152 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/mvr-garcia/fullgo/api/auth"
 	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/password"
 	"github.com/mvr-garcia/fullgo/api/responses"
 	"github.com/mvr-garcia/fullgo/api/utils"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-func (s *Server) SignIn(email, password string) (string, error) {
+// LoginResponse carries either the token pair or, for accounts with 2FA
+// enabled, the mfa_pending token to exchange at /login/2fa.
+type LoginResponse struct {
+	*auth.TokenPair
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+var (
+	// Locks an account out after 5 failures, from any address
+	accountThrottle = models.Throttle{
+		Threshold: 5,
+		BaseDelay: time.Second * 30,
+		MaxDelay:  time.Minute * 15,
+		Window:    time.Hour * 24,
+	}
+	// Locks a client out after 20 failures, across any accounts
+	ipThrottle = models.Throttle{
+		Threshold: 20,
+		BaseDelay: time.Minute * 1,
+		MaxDelay:  time.Hour * 1,
+		Window:    time.Hour * 24,
+	}
+	// Limits the password reset and login links emailed for an address.
+	// Every request counts, sending emails is what is being limited
+	emailThrottle = models.Throttle{
+		Threshold: 3,
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Minute * 15,
+		Window:    time.Hour,
+	}
+)
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// verifyDummyPassword spends the same time as a real hash comparison so
+// unknown emails cannot be told apart by response time.
+func verifyDummyPassword(plain string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = models.GenerateHash("not a real password")
+	})
+	models.VerifyPassword(string(dummyHash), plain)
+}
+
+// SignIn checks the credentials, the tokens get the requested scopes or the
+// role defaults when none are given.
+func (s *Server) SignIn(email, plain string, scopes ...string) (*LoginResponse, error) {
+	return s.signIn(email, plain, scopes, false, auth.ClientInfo{})
+}
+
+func (s *Server) signIn(email, plain string, scopes []string, session bool, client auth.ClientInfo) (*LoginResponse, error) {
 
 	user := models.User{}
 	err := s.DB.Model(&models.User{}).Where("email = ?", email).Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		verifyDummyPassword(plain)
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = models.VerifyPassword(user.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", err
+	err = models.VerifyPassword(user.Password, plain)
+	if err != nil {
+		if err != password.ErrMismatch {
+			log.Printf("cannot verify password of user %d: %v", user.ID, err)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// Upgrade hashes from older algorithms or parameters while the plain
+	// text password is at hand
+	if password.NeedsRehash(user.Password) {
+		err = user.RehashPassword(s.DB, plain)
+		if err != nil {
+			log.Printf("cannot rehash password of user %d: %v", user.ID, err)
+		}
+	}
+
+	return s.completeLogin(&user, scopes, session, client)
+}
+
+// completeLogin runs once the user proved who they are, asking for the
+// second factor when 2FA is enabled. The session flag is carried over to
+// the second step.
+func (s *Server) completeLogin(user *models.User, scopes []string, session bool, client auth.ClientInfo) (*LoginResponse, error) {
+
+	scopes, err := auth.RestrictScopes(user.Role, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, err := auth.CreatePurposeToken(auth.TokenUseMFAPending, mfaPendingTTL, jwt.MapClaims{
+			"user_id": user.ID,
+			"scopes":  scopes,
+			"session": session,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResponse{MFARequired: true, MFAToken: mfaToken}, nil
 	}
 
-	return auth.CreateToken(user.ID)
+	tokens, err := s.IssueTokenPair(user, "", scopes, client)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{TokenPair: tokens}, nil
+}
+
+// claimScopes reads the scopes requested at login back from a purpose token
+func claimScopes(claims jwt.MapClaims) []string {
+	scopes := []string{}
+	if requested, ok := claims["scopes"].([]interface{}); ok {
+		for _, scope := range requested {
+			if scope, ok := scope.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
 }
 
 func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +165,18 @@ func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Clients can ask for a token with fewer scopes than their role allows,
+	// browsers can ask for the tokens to be kept in cookies
+	req := struct {
+		Scopes  []string `json:"scopes"`
+		Session bool     `json:"session"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
 	user.Prepare()
 	err = user.Validate("login")
 	if err != nil {
@@ -50,12 +184,54 @@ func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := s.SignIn(user.Email, user.Password)
+	accountKey := "email:" + strings.ToLower(user.Email)
+	ipKey := "ip:" + utils.ClientIP(r)
+	if !s.checkThrottle(w, accountThrottle, accountKey) || !s.checkThrottle(w, ipThrottle, ipKey) {
+		return
+	}
+
+	tokens, err := s.signIn(user.Email, user.Password, req.Scopes, req.Session, clientInfo(r))
+	if err == ErrInvalidCredentials {
+		s.recordFailure(accountThrottle, accountKey)
+		s.recordFailure(ipThrottle, ipKey)
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if errors.Is(err, auth.ErrScopeNotAllowed) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
 	if err != nil {
-		formatedError := utils.FormatError(err.Error())
-		responses.ErrorResponse(w, http.StatusUnprocessableEntity, formatedError)
+		responses.ErrorResponse(w, http.StatusInternalServerError, errors.New("cannot sign in"))
 		return
 	}
 
-	responses.JsonResponse(w, http.StatusOK, token)
+	err = accountThrottle.Reset(s.DB, accountKey)
+	if err != nil {
+		log.Printf("cannot reset login attempts: %v", err)
+	}
+
+	writeLoginResponse(w, tokens, req.Session)
+}
+
+// checkThrottle answers 429 and returns false when key is locked out
+func (s *Server) checkThrottle(w http.ResponseWriter, throttle models.Throttle, key string) bool {
+	wait, err := throttle.Check(s.DB, key)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, errors.New("cannot sign in"))
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+		responses.ErrorResponse(w, http.StatusTooManyRequests, errors.New("too many failed attempts, try again later"))
+		return false
+	}
+	return true
+}
+
+func (s *Server) recordFailure(throttle models.Throttle, key string) {
+	err := throttle.Fail(s.DB, key)
+	if err != nil {
+		log.Printf("cannot record failed login: %v", err)
+	}
 }
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/badoux/checkmail"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/mailer"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+	"github.com/mvr-garcia/fullgo/api/utils"
+)
+
+// magicLinkCookie binds a login link to the browser that asked for it
+const magicLinkCookie = "magic_link"
+
+// bindMagicLinks is on unless MAGIC_LINK_BIND_BROWSER=false, for clients
+// that open links somewhere else than where they were requested
+func bindMagicLinks() bool {
+	return os.Getenv("MAGIC_LINK_BIND_BROWSER") != "false"
+}
+
+// RequestMagicLink emails a single-use login link. The response is the same
+// whether the email is registered or not, and the account is looked up only
+// after it is written.
+func (s *Server) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		Email   string   `json:"email"`
+		Scopes  []string `json:"scopes"`
+		Session bool     `json:"session"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if err := checkmail.ValidateFormat(req.Email); err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("invalid email"))
+		return
+	}
+
+	emailKey := "magic:" + strings.ToLower(req.Email)
+	ipKey := "magic-ip:" + utils.ClientIP(r)
+	if !s.checkThrottle(w, emailThrottle, emailKey) || !s.checkThrottle(w, ipThrottle, ipKey) {
+		return
+	}
+	s.recordFailure(emailThrottle, emailKey)
+	s.recordFailure(ipThrottle, ipKey)
+
+	browser := ""
+	if bindMagicLinks() {
+		nonce, err := auth.RandomToken(16)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		browser = auth.HashToken(nonce)
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    nonce,
+			Path:     "/login/magic",
+			MaxAge:   int(models.MagicLinkTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   secureCookies(),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	// A lookup before answering would make unknown emails answer faster
+	go func(email string) {
+		user := models.User{}
+		err := s.DB.Model(&models.User{}).Where("email = ?", email).Take(&user).Error
+		if err != nil {
+			return
+		}
+		err = s.sendMagicLink(&user, req.Scopes, req.Session, browser)
+		if err != nil {
+			log.Printf("cannot send login link to user %d: %v", user.ID, err)
+		}
+	}(req.Email)
+
+	responses.JsonResponse(w, http.StatusAccepted, "if the email is registered, a login link has been sent")
+}
+
+func (s *Server) sendMagicLink(user *models.User, scopes []string, session bool, browser string) error {
+
+	jti, err := auth.RandomToken(16)
+	if err != nil {
+		return err
+	}
+
+	link := models.MagicLink{
+		UserID: user.ID,
+		JTI:    jti,
+	}
+	_, err = link.SaveMagicLink(s.DB)
+	if err != nil {
+		return err
+	}
+
+	claims := jwt.MapClaims{
+		"jti":     jti,
+		"user_id": user.ID,
+		"email":   user.Email,
+		"scopes":  scopes,
+		"session": session,
+	}
+	if browser != "" {
+		claims["browser"] = browser
+	}
+
+	token, err := auth.CreatePurposeToken(auth.TokenUseMagicLink, models.MagicLinkTTL, claims)
+	if err != nil {
+		return err
+	}
+
+	// Only bound links are limited to the browser that requested them
+	bound := ""
+	if browser != "" {
+		bound = " It only works in the browser you requested it from."
+	}
+
+	loginURL := fmt.Sprintf("%s/login/magic/callback?token=%s", appURL(), url.QueryEscape(token))
+	return s.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to sign in. It expires in %s and can only be used once.%s\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+			user.Nickname, models.MagicLinkTTL, bound, loginURL,
+		),
+	})
+}
+
+// MagicLinkCallback exchanges a login link for the usual tokens, or the
+// mfa_pending token when 2FA is enabled
+func (s *Server) MagicLinkCallback(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ParsePurposeToken(r.URL.Query().Get("token"), auth.TokenUseMagicLink)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, models.ErrInvalidMagicLink)
+		return
+	}
+
+	// Checked before the link is used up, so it still works in the right browser
+	if browser, _ := claims["browser"].(string); browser != "" {
+		cookie, err := r.Cookie(magicLinkCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(auth.HashToken(cookie.Value)), []byte(browser)) != 1 {
+			responses.ErrorResponse(w, http.StatusForbidden, errors.New("open the link in the browser you requested it from"))
+			return
+		}
+	}
+
+	uid, _ := claims["user_id"].(float64)
+	jti, _ := claims["jti"].(string)
+	err = models.ConsumeMagicLink(s.DB, jti, uint32(uid))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: magicLinkCookie, Path: "/login/magic", MaxAge: -1})
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uint32(uid))
+	if err != nil || claims["email"] != user.Email {
+		responses.ErrorResponse(w, http.StatusBadRequest, models.ErrInvalidMagicLink)
+		return
+	}
+
+	// Receiving the link proves the address belongs to the user
+	if user.EmailVerifiedAt == nil {
+		err = user.VerifyEmail(s.DB, user.ID, user.Email)
+		if err != nil {
+			log.Printf("cannot verify email of user %d: %v", user.ID, err)
+		}
+	}
+
+	session, _ := claims["session"].(bool)
+	res, err := s.completeLogin(&user, claimScopes(claims), session, clientInfo(r))
+	if errors.Is(err, auth.ErrScopeNotAllowed) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, errors.New("cannot sign in"))
+		return
+	}
+
+	writeLoginResponse(w, res, session)
+}
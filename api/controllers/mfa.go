@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+const (
+	mfaPendingTTL     = time.Minute * 5
+	recoveryCodeCount = 10
+)
+
+type mfaRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func readMFARequest(r *http.Request) (mfaRequest, error) {
+	req := mfaRequest{}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+
+	err = json.Unmarshal(body, &req)
+	return req, err
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code
+func (s *Server) verifySecondFactor(user *models.User, code string) (bool, error) {
+
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if ok {
+		return user.UseTOTPStep(s.DB, user.ID, step)
+	}
+
+	return models.UseRecoveryCode(s.DB, user.ID, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+}
+
+func (s *Server) newRecoveryCodes(uid uint32) ([]string, error) {
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+
+	err = models.ReplaceRecoveryCodes(s.DB, uid, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// EnrollTOTP generates a secret that becomes active once ConfirmTOTP
+// receives a valid code for it.
+func (s *Server) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	if user.TOTPEnabled {
+		responses.ErrorResponse(w, http.StatusConflict, errors.New("two-factor authentication already enabled"))
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = user.SetTOTPSecret(s.DB, uid, secret)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "fullgo"
+	}
+
+	responses.JsonResponse(w, http.StatusOK, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+func (s *Server) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	req, err := readMFARequest(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	if user.TOTPEnabled {
+		responses.ErrorResponse(w, http.StatusConflict, errors.New("two-factor authentication already enabled"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("two-factor enrollment not started"))
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("invalid code"))
+		return
+	}
+
+	_, err = user.UseTOTPStep(s.DB, uid, step)
+	if err == nil {
+		err = user.EnableTOTP(s.DB, uid)
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	codes, err := s.newRecoveryCodes(uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// LoginMFA exchanges the mfa_pending token from /login and a second factor
+// for the token pair.
+func (s *Server) LoginMFA(w http.ResponseWriter, r *http.Request) {
+
+	req, err := readMFARequest(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	claims, err := auth.ParsePurposeToken(req.MFAToken, auth.TokenUseMFAPending)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	uid, _ := claims["user_id"].(float64)
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uint32(uid))
+	if err != nil || !user.TOTPEnabled {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	mfaKey := fmt.Sprintf("mfa:%d", user.ID)
+	if !s.checkThrottle(w, accountThrottle, mfaKey) {
+		return
+	}
+
+	ok, err := s.verifySecondFactor(&user, req.Code)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		s.recordFailure(accountThrottle, mfaKey)
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid code"))
+		return
+	}
+
+	err = accountThrottle.Reset(s.DB, mfaKey)
+	if err != nil {
+		log.Printf("cannot reset login attempts: %v", err)
+	}
+
+	tokens, err := s.IssueTokenPair(&user, "", claimScopes(claims), clientInfo(r))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, _ := claims["session"].(bool)
+	writeLoginResponse(w, &LoginResponse{TokenPair: tokens}, session)
+}
+
+// DisableTOTP requires both the password and a second factor
+func (s *Server) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	req, err := readMFARequest(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	if !user.TOTPEnabled {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("two-factor authentication not enabled"))
+		return
+	}
+
+	if models.VerifyPassword(user.Password, req.Password) != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("incorrect password"))
+		return
+	}
+
+	ok, err := s.verifySecondFactor(&user, req.Code)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid code"))
+		return
+	}
+
+	err = user.DisableTOTP(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, "two-factor authentication disabled")
+}
+
+func (s *Server) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	req, err := readMFARequest(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	if !user.TOTPEnabled {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("two-factor authentication not enabled"))
+		return
+	}
+
+	ok, err := s.verifySecondFactor(&user, req.Code)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("invalid code"))
+		return
+	}
+
+	codes, err := s.newRecoveryCodes(uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/oidc"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = time.Minute * 10
+)
+
+// initializeOIDC loads the configured providers and, with OIDC_MOCK=true,
+// serves a mock provider under /mock-oidc for offline development. The mock
+// lets anyone sign in as any email, so APP_ENV has to be development or test.
+func (s *Server) initializeOIDC() {
+
+	s.OIDCProviders = oidc.ProvidersFromEnv()
+
+	if os.Getenv("OIDC_MOCK") != "true" {
+		return
+	}
+	if env := os.Getenv("APP_ENV"); env != "development" && env != "test" {
+		log.Println("OIDC_MOCK ignored: APP_ENV must be development or test")
+		return
+	}
+
+	mock, err := oidc.NewMockProvider(appURL()+"/mock-oidc", "fullgo")
+	if err != nil {
+		log.Fatal("Cannot start the mock OIDC provider: ", err)
+	}
+
+	s.Router.PathPrefix("/mock-oidc/").Handler(http.StripPrefix("/mock-oidc", mock))
+	s.OIDCProviders["mock"] = &oidc.Provider{
+		Name:        "mock",
+		Issuer:      mock.Issuer,
+		ClientID:    mock.ClientID,
+		RedirectURL: appURL() + "/login/mock/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+	}
+}
+
+// OIDCLogin redirects the browser to the provider. The state, nonce and
+// PKCE verifier travel in a signed cookie until the callback.
+func (s *Server) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["provider"]
+	provider, ok := s.OIDCProviders[name]
+	if !ok {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("unknown provider"))
+		return
+	}
+
+	state, err := auth.RandomToken(16)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	nonce, err := auth.RandomToken(16)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, challenge)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadGateway, err)
+		return
+	}
+
+	cookie, err := auth.CreatePurposeToken(auth.TokenUseOIDCState, oidcStateTTL, jwt.MapClaims{
+		"provider": name,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	})
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/login/" + name,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Passed through so the user does not have to pick the account again
+	if hint := r.URL.Query().Get("login_hint"); hint != "" {
+		authURL += "&login_hint=" + url.QueryEscape(hint)
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (s *Server) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["provider"]
+	provider, ok := s.OIDCProviders[name]
+	if !ok {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("unknown provider"))
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("login rejected by provider: "+query.Get("error")))
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("missing login state"))
+		return
+	}
+
+	// The state cookie is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/" + name, MaxAge: -1})
+
+	claims, err := auth.ParsePurposeToken(cookie.Value, auth.TokenUseOIDCState)
+	if err != nil || claims["provider"] != name {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid login state"))
+		return
+	}
+
+	state, _ := claims["state"].(string)
+	if subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid login state"))
+		return
+	}
+
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	identity, err := provider.Exchange(query.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("oidc %s: %v", name, err)
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("cannot sign in with provider"))
+		return
+	}
+
+	user, err := models.LinkExternalIdentity(s.DB, name, identity.Subject, identity.Email, identity.EmailVerified, identity.Name)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tokens, err := s.completeLogin(user, nil, false, clientInfo(r))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, tokens)
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+// pageResponse is the envelope of paginated listings
+type pageResponse struct {
+	Data       interface{} `json:"data"`
+	NextCursor *string     `json:"next_cursor"`
+}
+
+// parsePageQuery reads ?limit=&after=&sort=&order=&author_id=
+// &created_after=&created_before= with sort restricted to sorts
+func parsePageQuery(r *http.Request, sorts []string) (models.PageQuery, error) {
+
+	query := r.URL.Query()
+	page := models.PageQuery{Limit: models.DefaultPageLimit, Sort: "created_at"}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return page, errors.New("invalid limit")
+		}
+		if limit > models.MaxPageLimit {
+			limit = models.MaxPageLimit
+		}
+		page.Limit = limit
+	}
+
+	if v := query.Get("sort"); v != "" {
+		valid := false
+		for _, sort := range sorts {
+			valid = valid || sort == v
+		}
+		if !valid {
+			return page, fmt.Errorf("invalid sort, use one of %s", strings.Join(sorts, ", "))
+		}
+		page.Sort = v
+	}
+
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, errors.New("invalid order, use asc or desc")
+	}
+
+	if v := query.Get("after"); v != "" {
+		cursor, err := models.DecodeCursor(v)
+		if err != nil {
+			return page, err
+		}
+		page.After = cursor
+	}
+
+	if v := query.Get("author_id"); v != "" {
+		aid, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return page, errors.New("invalid author_id")
+		}
+		page.AuthorID = uint32(aid)
+	}
+
+	if v := query.Get("tag"); v != "" {
+		_, page.Tag = models.NormalizeTag(v)
+	}
+
+	var err error
+	page.CreatedAfter, err = parseDateParam(query.Get("created_after"))
+	if err != nil {
+		return page, errors.New("invalid created_after")
+	}
+	page.CreatedBefore, err = parseDateParam(query.Get("created_before"))
+	if err != nil {
+		return page, errors.New("invalid created_before")
+	}
+
+	return page, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps and plain dates
+func parseDateParam(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// writePage answers with the envelope and RFC 8288 Link headers pointing at
+// the first and next pages
+func writePage(w http.ResponseWriter, r *http.Request, data interface{}, next string) {
+
+	query := r.URL.Query()
+	query.Del("after")
+	w.Header().Add("Link", fmt.Sprintf(`<%s%s?%s>; rel="first"`, appURL(), r.URL.Path, query.Encode()))
+
+	res := pageResponse{Data: data}
+	if next != "" {
+		query.Set("after", next)
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, appURL(), r.URL.Path, query.Encode()))
+		res.NextCursor = &next
+	}
+
+	responses.JsonResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/badoux/checkmail"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/mailer"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/password"
+	"github.com/mvr-garcia/fullgo/api/responses"
+	"github.com/mvr-garcia/fullgo/api/utils"
+)
+
+// ForgotPassword always answers the same way so it cannot be used to find
+// out which emails have an account.
+func (s *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		Email string `json:"email"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if err := checkmail.ValidateFormat(req.Email); err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("invalid email"))
+		return
+	}
+
+	emailKey := "reset:" + strings.ToLower(req.Email)
+	ipKey := "reset-ip:" + utils.ClientIP(r)
+	if !s.checkThrottle(w, emailThrottle, emailKey) || !s.checkThrottle(w, ipThrottle, ipKey) {
+		return
+	}
+	s.recordFailure(emailThrottle, emailKey)
+	s.recordFailure(ipThrottle, ipKey)
+
+	// Looking the user up and sending happen after the response, so its
+	// timing does not depend on whether the email is registered
+	go func(email string) {
+		user := models.User{}
+		err := s.DB.Model(&models.User{}).Where("email = ?", email).Take(&user).Error
+		if err != nil {
+			return
+		}
+		err = s.sendPasswordReset(&user)
+		if err != nil {
+			log.Printf("cannot send password reset to user %d: %v", user.ID, err)
+		}
+	}(req.Email)
+
+	responses.JsonResponse(w, http.StatusAccepted, "if the email is registered, a reset link has been sent")
+}
+
+func (s *Server) sendPasswordReset(user *models.User) error {
+
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	reset := models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+	}
+	_, err = reset.SavePasswordReset(s.DB)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?token=%s", passwordResetURL(), url.QueryEscape(token))
+	return s.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password. It expires in %s and can only be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.",
+			user.Nickname, models.PasswordResetTTL, link,
+		),
+	})
+}
+
+var passwordResetForm = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<form method="post" action="/password/reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+</body>
+</html>
+`))
+
+// PasswordResetForm is where the emailed link lands when no frontend page is
+// configured with PASSWORD_RESET_URL. It posts the token and new password
+// back to ResetPassword.
+func (s *Server) PasswordResetForm(w http.ResponseWriter, r *http.Request) {
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	err := passwordResetForm.Execute(w, token)
+	if err != nil {
+		log.Printf("cannot render password reset form: %v", err)
+	}
+}
+
+// ResetPassword takes the token and new password as JSON, or as the form
+// PasswordResetForm serves.
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		err := r.ParseForm()
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		req.Token = r.PostForm.Get("token")
+		req.Password = r.PostForm.Get("password")
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	if req.Token == "" {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("required token"))
+		return
+	}
+	if req.Password == "" {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("required password"))
+		return
+	}
+
+	// Check the policy before the token is used up, so it can be retried
+	reset, err := models.FindPasswordReset(s.DB, auth.HashToken(req.Token))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, reset.UserID, req.Password) {
+		return
+	}
+
+	reset, err = models.ConsumePasswordReset(s.DB, auth.HashToken(req.Token))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	user := models.User{}
+	err = user.UpdatePassword(s.DB, reset.UserID, req.Password)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in
+	err = models.RevokeUserTokens(s.DB, reset.UserID)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, "password updated")
+}
+
+// checkPasswordPolicy answers 422 with the broken rules as field errors of
+// "password" and reports whether the password can be used.
+func (s *Server) checkPasswordPolicy(w http.ResponseWriter, uid uint32, plain string) bool {
+	err := models.CheckPasswordPolicy(s.DB, uid, plain)
+
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		responses.ValidationErrorResponse(w, http.StatusUnprocessableEntity, errors.New("invalid password"),
+			map[string][]string{"password": policyErr.Violations})
+		return false
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
+
+// passwordResetURL is the page the reset link points to: PASSWORD_RESET_URL
+// for a frontend, otherwise the form served by PasswordResetForm
+func passwordResetURL() string {
+	if u := os.Getenv("PASSWORD_RESET_URL"); u != "" {
+		return u
+	}
+	return appURL() + "/password/reset"
+}
+
+// appURL is the public base URL used in links sent by email
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:8080"
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+type createdTokenResponse struct {
+	*models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// tokenOwner checks the caller may manage the tokens of the user in the path
+func tokenOwner(w http.ResponseWriter, r *http.Request) (uint32, bool) {
+
+	vars := mux.Vars(r)
+	uid, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return 0, false
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return 0, false
+	}
+
+	if !principal.CanManageUser(uint32(uid)) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return 0, false
+	}
+
+	return uint32(uid), true
+}
+
+func (s *Server) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	// Admins may list and revoke the tokens of others but not act as them
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil || principal.UserID != uid {
+		responses.ErrorResponse(w, http.StatusForbidden, errors.New("tokens can only be created for your own account"))
+		return
+	}
+
+	// A leaked token must not be able to mint more tokens
+	claims, err := auth.ExtractClaims(r)
+	if err != nil || claims.IsPersonalAccessToken() {
+		responses.ErrorResponse(w, http.StatusForbidden, errors.New("personal access tokens cannot create tokens"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	pat := models.PersonalAccessToken{}
+	err = json.Unmarshal(body, &pat)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	pat.Prepare()
+	pat.UserID = uid
+	err = pat.Validate()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	owner := models.User{}
+	_, err = owner.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	scopes, err := auth.RestrictScopes(owner.Role, pat.Scopes)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// A token narrowed at login cannot mint a broader one
+	for _, scope := range scopes {
+		if scope != auth.ScopeReadOnly && !principal.HasScope(scope) {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, fmt.Errorf("%w: %s", auth.ErrScopeNotAllowed, scope))
+			return
+		}
+	}
+	pat.Scopes = scopes
+
+	token, err := auth.NewPersonalAccessToken()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	pat.TokenHash = auth.HashToken(token)
+	pat.Prefix = token[:len(auth.PersonalAccessTokenPrefix)+4]
+
+	patCreated, err := pat.SavePersonalAccessToken(s.DB)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, patCreated.ID))
+	responses.JsonResponse(w, http.StatusCreated, createdTokenResponse{PersonalAccessToken: patCreated, Token: token})
+}
+
+func (s *Server) GetPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	pat := models.PersonalAccessToken{}
+	tokens, err := pat.FindUserPersonalAccessTokens(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, tokens)
+}
+
+func (s *Server) DeletePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	tid, err := strconv.ParseUint(vars["tid"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pat := models.PersonalAccessToken{}
+	err = pat.RevokePersonalAccessToken(s.DB, tid, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Entity", fmt.Sprintf("%d", tid))
+	responses.JsonResponse(w, http.StatusNoContent, "")
+}
@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mvr-garcia/fullgo/api/auth"
@@ -37,17 +40,44 @@ func (s *Server) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uid, err := auth.ExtractTokenID(r)
+	// New posts are drafts unless asked otherwise
+	if post.Status == "" {
+		post.Status = models.PostDraft
+	}
+	err = post.SetStatus(post.Status, post.PublishAt)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	if uid != post.AuthorID {
+	if !principal.CanManageUser(post.AuthorID) {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
+	author := models.User{}
+	_, err = author.FindUserByID(s.DB, post.AuthorID)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("author not found"))
+		return
+	}
+
+	if author.EmailVerifiedAt == nil {
+		responses.ErrorResponse(w, http.StatusForbidden, errors.New("email not verified"))
+		return
+	}
+
+	// Tags are created here, so only once the post is allowed
+	if !s.preparePostRelations(w, &post) {
+		return
+	}
+
 	postCreated, err := post.SavePost(s.DB)
 	if err != nil {
 		formattedError := utils.FormatError(err.Error())
@@ -55,20 +85,79 @@ func (s *Server) CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.indexPost(postCreated)
+
 	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, postCreated.ID))
 	responses.JsonResponse(w, http.StatusCreated, postCreated)
 }
 
 func (s *Server) GetPosts(w http.ResponseWriter, r *http.Request) {
 
+	page, err := parsePageQuery(r, models.PostSorts)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Posts of a category include those of its subcategories
+	if slug := r.URL.Query().Get("category"); slug != "" {
+		category, err := models.FindCategoryBySlug(s.DB, slug)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusNotFound, err)
+			return
+		}
+		page.CategoryIDs, err = models.CategoryDescendants(s.DB, category.ID)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	s.writePostPage(w, r, page)
+}
+
+// writePostPage lists published posts. Others are listed with ?status= for
+// their author, or for moderators along with ?author_id=.
+func (s *Server) writePostPage(w http.ResponseWriter, r *http.Request, page models.PageQuery) {
+
+	page.Status = models.PostPublished
+	if status := r.URL.Query().Get("status"); status != "" && status != models.PostPublished {
+		valid := false
+		for _, v := range models.PostStatuses {
+			valid = valid || v == status
+		}
+		if !valid {
+			responses.ErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid status, use one of %s", strings.Join(models.PostStatuses, ", ")))
+			return
+		}
+
+		principal, err := auth.ExtractPrincipal(r)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		if page.AuthorID == 0 {
+			page.AuthorID = principal.UserID
+		}
+		if !principal.CanModerate(page.AuthorID) {
+			responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		page.Status = status
+	}
+
 	post := models.Post{}
-	posts, err := post.FindAllPosts(s.DB)
+	posts, next, err := post.FindAllPosts(s.DB, page)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JsonResponse(w, http.StatusOK, posts)
+	writePage(w, r, posts, next)
 }
 
 func (s *Server) GetPost(w http.ResponseWriter, r *http.Request) {
@@ -87,6 +176,40 @@ func (s *Server) GetPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !canViewPost(r, postReceived) {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("post not found"))
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, postReceived)
+}
+
+// GetPostBySlug answers old slugs of a post with a permanent redirect to
+// its current one
+func (s *Server) GetPostBySlug(w http.ResponseWriter, r *http.Request) {
+
+	postSlug, err := models.FindPostSlug(s.DB, mux.Vars(r)["slug"])
+	if errors.Is(err, models.ErrPostSlugNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	post := models.Post{}
+	postReceived, err := post.FindPostByID(s.DB, postSlug.PostID)
+	if err != nil || !canViewPost(r, postReceived) {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("post not found"))
+		return
+	}
+
+	if postReceived.Slug != postSlug.Slug {
+		http.Redirect(w, r, "/posts/by-slug/"+url.PathEscape(postReceived.Slug), http.StatusMovedPermanently)
+		return
+	}
+
 	responses.JsonResponse(w, http.StatusOK, postReceived)
 }
 
@@ -99,7 +222,7 @@ func (s *Server) UpdatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uid, err := auth.ExtractTokenID(r)
+	principal, err := auth.ExtractPrincipal(r)
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
@@ -112,7 +235,7 @@ func (s *Server) UpdatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if uid != post.AuthorID {
+	if !principal.CanModerate(post.AuthorID) {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
 	}
@@ -130,31 +253,126 @@ func (s *Server) UpdatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Is the authenticated user, the owner of this post?
-	if uid != postUpdate.AuthorID {
+	// The author of a post cannot be changed
+	if postUpdate.AuthorID != 0 && postUpdate.AuthorID != post.AuthorID {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
 	}
+	postUpdate.AuthorID = post.AuthorID
 
 	postUpdate.Prepare()
-	err = post.Validate()
+	err = postUpdate.Validate()
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
 		return
 	}
 
+	// The status only changes when one is sent
+	postUpdate.PublishedAt = post.PublishedAt
+	if postUpdate.Status == "" {
+		postUpdate.Status, postUpdate.PublishAt = post.Status, post.PublishAt
+	}
+	err = postUpdate.SetStatus(postUpdate.Status, postUpdate.PublishAt)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if !s.preparePostRelations(w, &postUpdate) {
+		return
+	}
+
 	postUpdate.ID = post.ID
 
-	postUpdated, err := postUpdate.UpdateAPost(s.DB)
+	postUpdated, err := postUpdate.UpdateAPost(s.DB, principal.UserID)
 	if err != nil {
 		formatedError := utils.FormatError(err.Error())
 		responses.ErrorResponse(w, http.StatusInternalServerError, formatedError)
 		return
 	}
 
+	s.indexPost(postUpdated)
+
 	responses.JsonResponse(w, http.StatusOK, postUpdated)
 }
 
+// canViewPost lets anyone read published posts, and only their author or a
+// moderator the others
+func canViewPost(r *http.Request, post *models.Post) bool {
+	if post.IsPublished() {
+		return true
+	}
+	principal, err := auth.ExtractPrincipal(r)
+	return err == nil && principal.CanModerate(post.AuthorID)
+}
+
+// PublishPost publishes the post now, or schedules it when a publish_at is
+// sent
+func (s *Server) PublishPost(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	pid, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	post := models.Post{}
+	err = s.DB.Model(&models.Post{}).Where("id = ?", pid).Take(&post).Error
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("post not found"))
+		return
+	}
+
+	if !principal.CanModerate(post.AuthorID) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		PublishAt *time.Time `json:"publish_at"`
+	}{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	status := models.PostPublished
+	if req.PublishAt != nil {
+		status = models.PostScheduled
+	}
+	err = post.SetStatus(status, req.PublishAt)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	postPublished, err := post.UpdatePostStatus(s.DB)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.indexPost(postPublished)
+
+	responses.JsonResponse(w, http.StatusOK, postPublished)
+}
+
 func (s *Server) DeletePost(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	pid, err := strconv.ParseUint(vars["id"], 10, 64)
@@ -163,7 +381,7 @@ func (s *Server) DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uid, err := auth.ExtractTokenID(r)
+	principal, err := auth.ExtractPrincipal(r)
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
@@ -176,18 +394,20 @@ func (s *Server) DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Is the authenticated user, the owner of this post?
-	if uid != post.AuthorID {
+	// Is the authenticated user the owner of this post or a moderator?
+	if !principal.CanModerate(post.AuthorID) {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
 	}
 
-	_, err = post.DeleteAPost(s.DB, pid, uid)
+	_, err = post.DeleteAPost(s.DB, pid, post.AuthorID)
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusBadRequest, err)
 		return
 	}
 
+	s.unindexPost(pid)
+
 	w.Header().Set("Entity", fmt.Sprintf("%d", pid))
 	responses.JsonResponse(w, http.StatusOK, "")
 }
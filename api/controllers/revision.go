@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+	"github.com/mvr-garcia/fullgo/api/utils"
+)
+
+// diffContext is the number of unchanged lines around unified diff hunks
+const diffContext = 3
+
+// revisionDiff compares the title and content of two revisions, as unified
+// diff text or as word ops
+type revisionDiff struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Format  string      `json:"format"`
+	Title   interface{} `json:"title"`
+	Content interface{} `json:"content"`
+}
+
+// revisionPost loads the {id} post, whose history only its author and
+// moderators may see
+func (s *Server) revisionPost(w http.ResponseWriter, r *http.Request) (*models.Post, auth.Principal, bool) {
+
+	pid, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return nil, auth.Principal{}, false
+	}
+
+	principal, err := auth.ExtractPrincipal(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return nil, auth.Principal{}, false
+	}
+
+	post := models.Post{}
+	err = s.DB.Model(&models.Post{}).Where("id = ?", pid).Take(&post).Error
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("post not found"))
+		return nil, auth.Principal{}, false
+	}
+
+	if !principal.CanModerate(post.AuthorID) {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return nil, auth.Principal{}, false
+	}
+	return &post, principal, true
+}
+
+// findRevision loads revision number v of the post
+func (s *Server) findRevision(w http.ResponseWriter, pid uint64, v string) (*models.PostRevision, bool) {
+
+	number, err := strconv.Atoi(v)
+	if err != nil || number < 1 {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid revision"))
+		return nil, false
+	}
+
+	revision, err := models.FindPostRevision(s.DB, pid, number)
+	if errors.Is(err, models.ErrRevisionNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return revision, true
+}
+
+func (s *Server) GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+
+	post, _, ok := s.revisionPost(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parsePageQuery(r, models.RevisionSorts)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	revisions, next, err := models.FindPostRevisions(s.DB, post.ID, page)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writePage(w, r, revisions, next)
+}
+
+func (s *Server) GetPostRevision(w http.ResponseWriter, r *http.Request) {
+
+	post, _, ok := s.revisionPost(w, r)
+	if !ok {
+		return
+	}
+
+	revision, ok := s.findRevision(w, post.ID, mux.Vars(r)["rev"])
+	if !ok {
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, revision)
+}
+
+// DiffPostRevision compares {rev} with ?from=, the revision before it by
+// default. ?format= is unified (default) or word.
+func (s *Server) DiffPostRevision(w http.ResponseWriter, r *http.Request) {
+
+	post, _, ok := s.revisionPost(w, r)
+	if !ok {
+		return
+	}
+
+	to, ok := s.findRevision(w, post.ID, mux.Vars(r)["rev"])
+	if !ok {
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		if to.Number == 1 {
+			responses.ErrorResponse(w, http.StatusBadRequest, errors.New("required from, there is no earlier revision"))
+			return
+		}
+		from = strconv.Itoa(to.Number - 1)
+	}
+	fromRevision, ok := s.findRevision(w, post.ID, from)
+	if !ok {
+		return
+	}
+
+	diff := revisionDiff{From: fromRevision.Number, To: to.Number}
+	switch r.URL.Query().Get("format") {
+	case "", "unified":
+		fromName, toName := fmt.Sprintf("revision %d", fromRevision.Number), fmt.Sprintf("revision %d", to.Number)
+		diff.Format = "unified"
+		diff.Title = utils.UnifiedDiff(fromName, toName, fromRevision.Title, to.Title, diffContext)
+		diff.Content = utils.UnifiedDiff(fromName, toName, fromRevision.Content, to.Content, diffContext)
+	case "word":
+		diff.Format = "word"
+		diff.Title = utils.WordDiff(fromRevision.Title, to.Title)
+		diff.Content = utils.WordDiff(fromRevision.Content, to.Content)
+	default:
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid format, use unified or word"))
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, diff)
+}
+
+func (s *Server) RestorePostRevision(w http.ResponseWriter, r *http.Request) {
+
+	post, principal, ok := s.revisionPost(w, r)
+	if !ok {
+		return
+	}
+
+	revision, ok := s.findRevision(w, post.ID, mux.Vars(r)["rev"])
+	if !ok {
+		return
+	}
+
+	postRestored, err := models.RestorePostRevision(s.DB, revision, principal.UserID)
+	if err != nil {
+		formattedError := utils.FormatError(err.Error())
+		responses.ErrorResponse(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+
+	s.indexPost(postRestored)
+
+	responses.JsonResponse(w, http.StatusOK, postRestored)
+}
@@ -1,6 +1,10 @@
 package controllers
 
-import "github.com/mvr-garcia/fullgo/api/middlewares"
+import (
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/middlewares"
+	"github.com/mvr-garcia/fullgo/api/storage"
+)
 
 func (s *Server) InitializeRoutes() {
 
@@ -9,18 +13,95 @@ func (s *Server) InitializeRoutes() {
 
 	// Login Route
 	s.Router.HandleFunc("/login", middlewares.SetMiddlewareJson(s.Login)).Methods("POST")
+	s.Router.HandleFunc("/login/2fa", middlewares.SetMiddlewareJson(s.LoginMFA)).Methods("POST")
+	s.Router.HandleFunc("/login/magic", middlewares.SetMiddlewareJson(s.RequestMagicLink)).Methods("POST")
+	s.Router.HandleFunc("/login/magic/callback", middlewares.SetMiddlewareJson(s.MagicLinkCallback)).Methods("GET")
+	s.Router.HandleFunc("/login/{provider}", s.OIDCLogin).Methods("GET")
+	s.Router.HandleFunc("/login/{provider}/callback", middlewares.SetMiddlewareJson(s.OIDCCallback)).Methods("GET")
+
+	// Token routes
+	s.Router.HandleFunc("/.well-known/jwks.json", middlewares.SetMiddlewareJson(s.JWKS)).Methods("GET")
+	s.Router.HandleFunc("/auth/refresh", middlewares.SetMiddlewareJson(s.RefreshToken)).Methods("POST")
+	s.Router.HandleFunc("/auth/introspect", middlewares.SetMiddlewareJson(s.Introspect)).Methods("POST")
+	s.Router.HandleFunc("/auth/logout", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.Logout))).Methods("POST")
+
+	// Two-factor authentication routes
+	s.Router.HandleFunc("/auth/2fa/enroll", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.EnrollTOTP), auth.ScopeUsersWrite))).Methods("POST")
+	s.Router.HandleFunc("/auth/2fa/confirm", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.ConfirmTOTP), auth.ScopeUsersWrite))).Methods("POST")
+	s.Router.HandleFunc("/auth/2fa/disable", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.DisableTOTP), auth.ScopeUsersWrite))).Methods("POST")
+	s.Router.HandleFunc("/auth/2fa/recovery-codes", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.RegenerateRecoveryCodes), auth.ScopeUsersWrite))).Methods("POST")
+
+	// Password reset routes
+	s.Router.HandleFunc("/password/forgot", middlewares.SetMiddlewareJson(s.ForgotPassword)).Methods("POST")
+	s.Router.HandleFunc("/password/reset", s.PasswordResetForm).Methods("GET")
+	s.Router.HandleFunc("/password/reset", middlewares.SetMiddlewareJson(s.ResetPassword)).Methods("POST")
+
+	// Email verification routes
+	s.Router.HandleFunc("/verify-email", middlewares.SetMiddlewareJson(s.VerifyEmail)).Methods("GET")
+	s.Router.HandleFunc("/verify-email/resend", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.ResendVerification))).Methods("POST")
 
 	//Users routes
 	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJson(s.CreateUser)).Methods("POST")
 	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJson(s.GetUsers)).Methods("GET")
 	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJson(s.GetUser)).Methods("GET")
-	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.UpdateUser))).Methods("PUT")
-	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareAuthentication(s.DeleteUser)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.UpdateUser), auth.ScopeUsersWrite))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.DeleteUser), auth.ScopeUsersWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/tokens", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.CreatePersonalAccessToken), auth.ScopeUsersWrite))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/tokens", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.GetPersonalAccessTokens))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/tokens/{tid}", middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.DeletePersonalAccessToken), auth.ScopeUsersWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/sessions", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.GetUserSessions))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/sessions/{sid}", middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.DeleteUserSession), auth.ScopeUsersWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/role", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.UpdateUserRole), auth.ScopeUsersAdmin))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}/avatar", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.UploadAvatar), auth.ScopeUsersWrite))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}/avatar", s.GetAvatar).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/avatar", middlewares.SetMiddlewareAuthentication(middlewares.DenyImpersonation(s.DeleteAvatar), auth.ScopeUsersWrite)).Methods("DELETE")
+
+	// Admin routes
+	s.Router.HandleFunc("/admin/users/{id}/impersonate", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, middlewares.DenyImpersonation(s.Impersonate)), auth.ScopeUsersAdmin))).Methods("POST")
+	s.Router.HandleFunc("/admin/impersonations", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.GetImpersonationSessions), auth.ScopeUsersAdmin))).Methods("GET")
 
 	//Posts routes
-	s.Router.HandleFunc("/posts", middlewares.SetMiddlewareJson(s.CreatePost)).Methods("POST")
+	s.Router.HandleFunc("/posts", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.CreatePost, auth.ScopePostsWrite))).Methods("POST")
 	s.Router.HandleFunc("/posts", middlewares.SetMiddlewareJson(s.GetPosts)).Methods("GET")
+	s.Router.HandleFunc("/posts/search", middlewares.SetMiddlewareJson(s.SearchPosts)).Methods("GET")
+	s.Router.HandleFunc("/posts/by-slug/{slug}", middlewares.SetMiddlewareJson(s.GetPostBySlug)).Methods("GET")
 	s.Router.HandleFunc("/posts/{id}", middlewares.SetMiddlewareJson(s.GetPost)).Methods("GET")
-	s.Router.HandleFunc("/posts/{id}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.UpdatePost))).Methods("PUT")
-	s.Router.HandleFunc("/posts/{id}", middlewares.SetMiddlewareAuthentication(s.DeletePost)).Methods("DELETE")
+	s.Router.HandleFunc("/posts/{id}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.UpdatePost, auth.ScopePostsWrite))).Methods("PUT")
+	s.Router.HandleFunc("/posts/{id}", middlewares.SetMiddlewareAuthentication(s.DeletePost, auth.ScopePostsWrite)).Methods("DELETE")
+	s.Router.HandleFunc("/posts/{id}/publish", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.PublishPost, auth.ScopePostsWrite))).Methods("POST")
+
+	// Attachment routes
+	s.Router.HandleFunc("/posts/{id}/attachments", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.UploadAttachment, auth.ScopePostsWrite))).Methods("POST")
+	s.Router.HandleFunc("/posts/{id}/attachments", middlewares.SetMiddlewareJson(s.GetAttachments)).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/attachments/{aid}", middlewares.SetMiddlewareJson(s.GetAttachment)).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/attachments/{aid}/download", s.DownloadAttachment).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/attachments/{aid}", middlewares.SetMiddlewareAuthentication(s.DeleteAttachment, auth.ScopePostsWrite)).Methods("DELETE")
+
+	// Files of the local storage, behind signed URLs
+	if local, ok := s.Storage.(*storage.Local); ok {
+		s.Router.PathPrefix(storage.LocalPrefix).Handler(local).Methods("GET", "HEAD")
+	}
+
+	// Revision routes
+	s.Router.HandleFunc("/posts/{id}/revisions", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.GetPostRevisions))).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/revisions/{rev}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.GetPostRevision))).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/revisions/{rev}/diff", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.DiffPostRevision))).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/revisions/{rev}/restore", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.RestorePostRevision, auth.ScopePostsWrite))).Methods("POST")
+
+	// Comment routes
+	s.Router.HandleFunc("/posts/{id}/comments", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.CreateComment, auth.ScopePostsWrite))).Methods("POST")
+	s.Router.HandleFunc("/posts/{id}/comments", middlewares.SetMiddlewareJson(s.GetComments)).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/comments/{cid}", middlewares.SetMiddlewareJson(s.GetComment)).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/comments/{cid}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(s.UpdateComment, auth.ScopePostsWrite))).Methods("PUT")
+	s.Router.HandleFunc("/posts/{id}/comments/{cid}", middlewares.SetMiddlewareAuthentication(s.DeleteComment, auth.ScopePostsWrite)).Methods("DELETE")
+
+	// Tags and categories routes
+	s.Router.HandleFunc("/tags", middlewares.SetMiddlewareJson(s.GetTags)).Methods("GET")
+	s.Router.HandleFunc("/tags/{slug}/posts", middlewares.SetMiddlewareJson(s.GetTagPosts)).Methods("GET")
+	s.Router.HandleFunc("/tags/{slug}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.RenameTag), auth.ScopePostsWrite))).Methods("PUT")
+	s.Router.HandleFunc("/tags/{slug}/merge", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.MergeTag), auth.ScopePostsWrite))).Methods("POST")
+	s.Router.HandleFunc("/categories", middlewares.SetMiddlewareJson(s.GetCategories)).Methods("GET")
+	s.Router.HandleFunc("/categories", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.CreateCategory), auth.ScopePostsWrite))).Methods("POST")
+	s.Router.HandleFunc("/categories/{id}", middlewares.SetMiddlewareJson(middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.UpdateCategory), auth.ScopePostsWrite))).Methods("PUT")
+	s.Router.HandleFunc("/categories/{id}", middlewares.SetMiddlewareAuthentication(middlewares.RequireRole(auth.RoleAdmin, s.DeleteCategory), auth.ScopePostsWrite)).Methods("DELETE")
 }
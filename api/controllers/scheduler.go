@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/models"
+)
+
+const defaultSchedulerInterval = time.Minute
+
+// schedulerInterval is how often scheduled posts are checked,
+// POST_SCHEDULER_INTERVAL takes a duration like 30s
+func schedulerInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("POST_SCHEDULER_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return defaultSchedulerInterval
+	}
+	return interval
+}
+
+// runPostScheduler publishes scheduled posts once their publish_at is due
+func (s *Server) runPostScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.publishDuePosts()
+	}
+}
+
+func (s *Server) publishDuePosts() {
+	ids, err := models.PublishDuePosts(s.DB, time.Now())
+	if err != nil {
+		log.Printf("cannot publish scheduled posts: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		post := models.Post{}
+		_, err := post.FindPostByID(s.DB, id)
+		if err != nil {
+			log.Printf("cannot index published post %d: %v", id, err)
+			continue
+		}
+		s.indexPost(&post)
+	}
+}
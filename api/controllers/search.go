@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+// indexPost keeps the search index current, a failure only affects search
+func (s *Server) indexPost(post *models.Post) {
+	err := s.PostSearch.IndexPost(post)
+	if err != nil {
+		log.Printf("cannot index post %d: %v", post.ID, err)
+	}
+}
+
+func (s *Server) unindexPost(pid uint64) {
+	err := s.PostSearch.RemovePost(pid)
+	if err != nil {
+		log.Printf("cannot remove post %d from the search index: %v", pid, err)
+	}
+}
+
+// SearchPosts answers ?q= with the matching posts, best first. Words must
+// all match, "quoted words" match as a phrase and word* as a prefix.
+func (s *Server) SearchPosts(w http.ResponseWriter, r *http.Request) {
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("required q"))
+		return
+	}
+
+	page, err := parsePageQuery(r, models.PostSorts)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Results are ordered by rank, the cursor is the number of hits seen
+	offset := 0
+	if page.After != nil {
+		offset, err = strconv.Atoi(page.After.Value)
+		if page.After.Sort != "rank" || err != nil || offset < 0 {
+			responses.ErrorResponse(w, http.StatusBadRequest, models.ErrInvalidCursor)
+			return
+		}
+	}
+
+	hits, more, err := s.PostSearch.SearchPosts(q, page.Limit, offset)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	results, err := models.FindSearchResults(s.DB, hits)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	next := ""
+	if more {
+		next = models.Cursor{Sort: "rank", Value: strconv.Itoa(offset + len(hits))}.Encode()
+	}
+	writePage(w, r, results, next)
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+// secureCookies is only off when the app is served over plain http, i.e.
+// local development
+func secureCookies() bool {
+	return !strings.HasPrefix(appURL(), "http://")
+}
+
+// writeLoginResponse answers with the tokens in the body or, in session
+// mode, stores them in HttpOnly cookies and only returns the CSRF token.
+func writeLoginResponse(w http.ResponseWriter, res *LoginResponse, session bool) {
+
+	if res.TokenPair == nil {
+		responses.JsonResponse(w, http.StatusOK, res)
+		return
+	}
+
+	tokens := *res.TokenPair
+	if session {
+		setSessionCookies(w, &tokens)
+		tokens.AccessToken = ""
+		tokens.RefreshToken = ""
+		tokens.TokenType = "cookie"
+	} else {
+		tokens.CSRFToken = ""
+	}
+
+	responses.JsonResponse(w, http.StatusOK, LoginResponse{TokenPair: &tokens})
+}
+
+func setSessionCookies(w http.ResponseWriter, tokens *auth.TokenPair) {
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.AccessTokenCookie,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		MaxAge:   int(auth.AccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Only sent where it is needed
+	for _, path := range []string{"/auth/refresh", "/auth/logout"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.RefreshTokenCookie,
+			Value:    tokens.RefreshToken,
+			Path:     path,
+			MaxAge:   int(auth.RefreshTokenTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   secureCookies(),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	// Readable by the front-end, which echoes it in the X-CSRF-Token header
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookie,
+		Value:    tokens.CSRFToken,
+		Path:     "/",
+		MaxAge:   int(auth.RefreshTokenTTL.Seconds()),
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	expired := time.Unix(0, 0)
+	http.SetCookie(w, &http.Cookie{Name: auth.AccessTokenCookie, Path: "/", Expires: expired, MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: auth.RefreshTokenCookie, Path: "/auth/refresh", Expires: expired, MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: auth.RefreshTokenCookie, Path: "/auth/logout", Expires: expired, MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: auth.CSRFCookie, Path: "/", Expires: expired, MaxAge: -1})
+}
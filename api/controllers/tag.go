@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+// preparePostRelations resolves the tags sent with a post into stored tags
+// and checks its category exists. Tags are left nil when none were sent.
+func (s *Server) preparePostRelations(w http.ResponseWriter, post *models.Post) bool {
+
+	if post.Tags != nil {
+		tags, err := models.ResolveTags(s.DB, models.TagNames(post.Tags))
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return false
+		}
+		post.Tags = tags
+	}
+
+	if post.CategoryID != nil {
+		_, err := models.FindCategoryByID(s.DB, *post.CategoryID)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) GetTags(w http.ResponseWriter, r *http.Request) {
+
+	tags, err := models.FindAllTags(s.DB)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, tags)
+}
+
+func (s *Server) GetTagPosts(w http.ResponseWriter, r *http.Request) {
+
+	tag, err := models.FindTagBySlug(s.DB, mux.Vars(r)["slug"])
+	if errors.Is(err, models.ErrTagNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page, err := parsePageQuery(r, models.PostSorts)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	page.Tag = tag.Slug
+
+	s.writePostPage(w, r, page)
+}
+
+// RenameTag is only routed for admins
+func (s *Server) RenameTag(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		Name string `json:"name"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tag, err := models.RenameTag(s.DB, mux.Vars(r)["slug"], req.Name)
+	if errors.Is(err, models.ErrTagNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, models.ErrTagExists) {
+		responses.ErrorResponse(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, tag)
+}
+
+// MergeTag is only routed for admins. The tag in the path is merged into
+// the one named by "into" and deleted.
+func (s *Server) MergeTag(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		Into string `json:"into"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	_, into := models.NormalizeTag(req.Into)
+	tag, err := models.MergeTags(s.DB, mux.Vars(r)["slug"], into)
+	if errors.Is(err, models.ErrTagNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, tag)
+}
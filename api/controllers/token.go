@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// IssueTokenPair creates an access token and a refresh token for the user.
+// An empty familyID starts a new refresh token family (a new login), the
+// scopes are carried over to every token of the family.
+func (s *Server) IssueTokenPair(user *models.User, familyID string, scopes []string, client auth.ClientInfo) (*auth.TokenPair, error) {
+
+	// Only checked when the tokens end up in session cookies
+	csrfToken, err := auth.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	if familyID == "" {
+		familyID, err = auth.RandomToken(16)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The refresh token family identifies the session
+	client.SessionID = familyID
+
+	scopes = auth.FilterScopes(user.Role, scopes)
+	accessToken, err := auth.CreateToken(user.ID, user.Role, scopes, auth.HashToken(csrfToken), client)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := models.RefreshToken{
+		TokenHash: auth.HashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL),
+	}
+	_, err = rt.SaveRefreshToken(s.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		CSRFToken:    csrfToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := refreshRequest{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	// Browser sessions send the refresh token as a cookie
+	session := false
+	if cookie, err := r.Cookie(auth.RefreshTokenCookie); req.RefreshToken == "" && err == nil {
+		if auth.VerifyCSRFCookie(r) != nil {
+			responses.ErrorResponse(w, http.StatusForbidden, auth.ErrInvalidCSRFToken)
+			return
+		}
+		req.RefreshToken = cookie.Value
+		session = true
+	}
+
+	if req.RefreshToken == "" {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("required refresh_token"))
+		return
+	}
+
+	rt := models.RefreshToken{}
+	_, err = rt.FindRefreshTokenByHash(s.DB, auth.HashToken(req.RefreshToken))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	err = rt.Consume(s.DB)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	// Reload the user so role changes are picked up on refresh
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, rt.UserID)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	tokens, err := s.IssueTokenPair(&user, rt.FamilyID, rt.Scopes, clientInfo(r))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeLoginResponse(w, &LoginResponse{TokenPair: tokens}, session)
+}
+
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	// The refresh token is optional, when given its whole family is revoked
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := refreshRequest{}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	if cookie, err := r.Cookie(auth.RefreshTokenCookie); req.RefreshToken == "" && err == nil {
+		req.RefreshToken = cookie.Value
+	}
+
+	if req.RefreshToken != "" {
+		rt := models.RefreshToken{}
+		_, err = rt.FindRefreshTokenByHash(s.DB, auth.HashToken(req.RefreshToken))
+		if err == nil && rt.UserID == uid {
+			err = models.RevokeRefreshTokenFamily(s.DB, rt.FamilyID)
+			if err != nil {
+				responses.ErrorResponse(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+
+	if claims.Id != "" {
+		revoked := models.RevokedToken{
+			JTI:       claims.Id,
+			UserID:    uid,
+			ExpiresAt: claims.ExpiresAtTime(),
+		}
+		err = revoked.SaveRevokedToken(s.DB)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	err = models.EndUserSession(s.DB, claims.Id)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if claims.IsImpersonation() {
+		err = models.EndImpersonationSession(s.DB, claims.Id)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	clearSessionCookies(w)
+	responses.JsonResponse(w, http.StatusOK, "")
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -36,6 +37,10 @@ func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.checkPasswordPolicy(w, 0, user.Password) {
+		return
+	}
+
 	userCreated, err := user.SaveUser(s.DB)
 	if err != nil {
 		formatedError := utils.FormatError(err.Error())
@@ -43,20 +48,38 @@ func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, err = userCreated.ReserveVerificationEmail(s.DB, userCreated.ID, 0)
+	if err == nil {
+		err = s.sendVerificationEmail(userCreated)
+	}
+	if err != nil {
+		log.Printf("cannot send verification email to user %d: %v", userCreated.ID, err)
+	}
+
 	w.Header().Set("location", fmt.Sprintf("%s%s/%d", r.Host, r.RequestURI, userCreated.ID))
 	responses.JsonResponse(w, http.StatusCreated, userCreated)
 }
 
 func (s *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
 
+	page, err := parsePageQuery(r, models.UserSorts)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
 	user := models.User{}
-	users, err := user.FindAllUsers(s.DB)
+	users, next, err := user.FindAllUsers(s.DB, page)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JsonResponse(w, http.StatusOK, users)
+	writePage(w, r, users, next)
 }
 
 func (s *Server) GetUser(w http.ResponseWriter, r *http.Request) {
@@ -100,13 +123,13 @@ func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	principal, err := auth.ExtractPrincipal(r)
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
 	}
 
-	if tokenID != uint32(uid) {
+	if !principal.CanManageUser(uint32(uid)) {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
@@ -118,6 +141,20 @@ func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Resending the current password is not a change
+	if !models.IsCurrentPassword(s.DB, uint32(uid), user.Password) {
+		if !s.checkPasswordPolicy(w, uint32(uid), user.Password) {
+			return
+		}
+	}
+
+	current := models.User{}
+	_, err = current.FindUserByID(s.DB, uint32(uid))
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
 	updatedUser, err := user.UpdateAUser(s.DB, uint32(uid))
 	if err != nil {
 		formatedError := utils.FormatError(err.Error())
@@ -125,6 +162,57 @@ func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The new address is unverified until the link sent to it is used
+	if updatedUser.Email != current.Email {
+		_, err = updatedUser.ReserveVerificationEmail(s.DB, updatedUser.ID, 0)
+		if err == nil {
+			err = s.sendVerificationEmail(updatedUser)
+		}
+		if err != nil {
+			log.Printf("cannot send verification email to user %d: %v", updatedUser.ID, err)
+		}
+	}
+
+	responses.JsonResponse(w, http.StatusOK, updatedUser)
+}
+
+// UpdateUserRole is only routed for admins
+func (s *Server) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	uid, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	req := struct {
+		Role string `json:"role"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if !auth.IsValidRole(req.Role) {
+		responses.ErrorResponse(w, http.StatusUnprocessableEntity, errors.New("invalid role"))
+		return
+	}
+
+	user := models.User{}
+	updatedUser, err := user.UpdateUserRole(s.DB, uint32(uid), req.Role)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
 	responses.JsonResponse(w, http.StatusOK, updatedUser)
 }
 
@@ -139,13 +227,13 @@ func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	principal, err := auth.ExtractPrincipal(r)
 	if err != nil {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
 	}
 
-	if tokenID != 0 && tokenID != uint32(uid) {
+	if !principal.CanManageUser(uint32(uid)) {
 		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
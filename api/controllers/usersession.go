@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+	"github.com/mvr-garcia/fullgo/api/utils"
+)
+
+// clientInfo describes the client tokens are issued to, for session tracking
+func clientInfo(r *http.Request) auth.ClientInfo {
+	return auth.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        utils.ClientIP(r),
+	}
+}
+
+func (s *Server) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := models.FindUserSessions(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	claims, err := auth.ExtractClaims(r)
+	if err == nil {
+		for i := range *sessions {
+			(*sessions)[i].Current = (*sessions)[i].JTI == claims.Id
+		}
+	}
+
+	responses.JsonResponse(w, http.StatusOK, sessions)
+}
+
+func (s *Server) DeleteUserSession(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := tokenOwner(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	sid, err := strconv.ParseUint(vars["sid"], 10, 64)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = models.RevokeUserSession(s.DB, uid, sid)
+	if errors.Is(err, models.ErrSessionNotFound) {
+		responses.ErrorResponse(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Entity", fmt.Sprintf("%d", sid))
+	responses.JsonResponse(w, http.StatusNoContent, "")
+}
+
+// introspectionClient checks the HTTP Basic credentials against
+// INTROSPECTION_CLIENTS, a comma separated list of id:secret pairs
+func introspectionClient(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	for _, pair := range strings.Split(os.Getenv("INTROSPECTION_CLIENTS"), ",") {
+		clientID, clientSecret, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || clientID != id {
+			continue
+		}
+		return subtle.ConstantTimeCompare([]byte(clientSecret), []byte(secret)) == 1
+	}
+	return false
+}
+
+// Introspect implements RFC 7662 for our other services. It takes a form
+// encoded token and optional token_type_hint and describes access tokens,
+// personal access tokens and refresh tokens.
+func (s *Server) Introspect(w http.ResponseWriter, r *http.Request) {
+
+	if !introspectionClient(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("required token"))
+		return
+	}
+
+	// The hint only decides which kind is looked up first
+	var result *auth.Introspection
+	if r.PostForm.Get("token_type_hint") == "refresh_token" {
+		result = s.introspectRefreshToken(token)
+		if !result.Active {
+			result = auth.IntrospectAccessToken(token)
+		}
+	} else {
+		result = auth.IntrospectAccessToken(token)
+		if !result.Active {
+			result = s.introspectRefreshToken(token)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	responses.JsonResponse(w, http.StatusOK, result)
+}
+
+func (s *Server) introspectRefreshToken(token string) *auth.Introspection {
+
+	rt := models.RefreshToken{}
+	_, err := rt.FindRefreshTokenByHash(s.DB, auth.HashToken(token))
+	if err != nil || rt.UsedAt != nil || rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return &auth.Introspection{Active: false}
+	}
+
+	return &auth.Introspection{
+		Active:    true,
+		Scope:     strings.Join(rt.Scopes, " "),
+		TokenType: "refresh_token",
+		Exp:       rt.ExpiresAt.Unix(),
+		Iat:       rt.CreatedAt.Unix(),
+		Sub:       auth.Subject(rt.UserID),
+	}
+}
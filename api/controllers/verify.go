@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"github.com/mvr-garcia/fullgo/api/mailer"
+	"github.com/mvr-garcia/fullgo/api/models"
+	"github.com/mvr-garcia/fullgo/api/responses"
+)
+
+const (
+	emailVerificationTTL      = time.Hour * 24
+	emailVerificationInterval = time.Minute * 2
+)
+
+func (s *Server) sendVerificationEmail(user *models.User) error {
+
+	token, err := auth.CreatePurposeToken(auth.TokenUseVerifyEmail, emailVerificationTTL, jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", appURL(), url.QueryEscape(token))
+	return s.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s",
+			user.Nickname, emailVerificationTTL, link,
+		),
+	})
+}
+
+func (s *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ParsePurposeToken(r.URL.Query().Get("token"), auth.TokenUseVerifyEmail)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, errors.New("invalid verification link"))
+		return
+	}
+
+	uid, _ := claims["user_id"].(float64)
+	email, _ := claims["email"].(string)
+
+	user := models.User{}
+	err = user.VerifyEmail(s.DB, uint32(uid), email)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusOK, "email verified")
+}
+
+func (s *Server) ResendVerification(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, uid)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		responses.ErrorResponse(w, http.StatusConflict, errors.New("email already verified"))
+		return
+	}
+
+	ok, err := user.ReserveVerificationEmail(s.DB, uid, emailVerificationInterval)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", emailVerificationInterval.Seconds()))
+		responses.ErrorResponse(w, http.StatusTooManyRequests, errors.New("verification email sent recently, try again later"))
+		return
+	}
+
+	err = s.sendVerificationEmail(&user)
+	if err != nil {
+		responses.ErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JsonResponse(w, http.StatusAccepted, "verification email sent")
+}
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"errors"
+	"log"
 	"net/http"
 
 	"github.com/mvr-garcia/fullgo/api/auth"
@@ -15,13 +16,79 @@ func SetMiddlewareJson(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func SetMiddlewareAuthentication(h http.HandlerFunc) http.HandlerFunc {
+// SetMiddlewareAuthentication requires a valid token holding every one of
+// the given scopes.
+func SetMiddlewareAuthentication(h http.HandlerFunc, scopes ...string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := auth.ValidateToken(r)
+		claims, err := auth.ExtractClaims(r)
 		if err != nil {
 			responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
 			return
 		}
+
+		err = auth.TouchSession(claims)
+		if err != nil {
+			log.Printf("cannot update session of user %d: %v", claims.UserID, err)
+		}
+
+		// Cookie authenticated requests are sent by browsers automatically
+		err = auth.VerifyCSRF(r)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusForbidden, auth.ErrInvalidCSRFToken)
+			return
+		}
+
+		if len(scopes) > 0 {
+			principal, err := auth.ExtractPrincipal(r)
+			if err != nil {
+				responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					responses.ErrorResponse(w, http.StatusForbidden, errors.New("insufficient scope: "+scope+" required"))
+					return
+				}
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// RequireRole only lets through authenticated callers holding at least role
+func RequireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := auth.ExtractPrincipal(r)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		if auth.VerifyCSRF(r) != nil {
+			responses.ErrorResponse(w, http.StatusForbidden, auth.ErrInvalidCSRFToken)
+			return
+		}
+		if !principal.HasRole(role) {
+			responses.ErrorResponse(w, http.StatusForbidden, errors.New("forbidden"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// DenyImpersonation keeps sensitive actions, like changing credentials, out
+// of reach of admins impersonating a user
+func DenyImpersonation(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.ExtractClaims(r)
+		if err != nil {
+			responses.ErrorResponse(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		if claims.IsImpersonation() {
+			responses.ErrorResponse(w, http.StatusForbidden, errors.New("not allowed while impersonating"))
+			return
+		}
 		h(w, r)
 	}
 }
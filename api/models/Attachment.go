@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// Attachment is a file uploaded to a post. The file itself is in the
+// storage under Key, URL is a signed link to it filled in when answering.
+type Attachment struct {
+	ID          uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	PostID      uint64    `gorm:"not null;index" json:"post_id"`
+	UploaderID  uint32    `gorm:"not null" json:"uploader_id"`
+	Key         string    `gorm:"size:255;not null;uniqueIndex" json:"-"`
+	Filename    string    `gorm:"size:255;not null" json:"filename"`
+	ContentType string    `gorm:"size:100;not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	URL         string    `gorm:"-" json:"url,omitempty"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (a *Attachment) SaveAttachment(db *gorm.DB) (*Attachment, error) {
+	err := db.Create(&a).Error
+	if err != nil {
+		return &Attachment{}, err
+	}
+	return a, nil
+}
+
+// FindPostAttachments lists the attachments of a post, oldest first
+func FindPostAttachments(db *gorm.DB, pid uint64) ([]Attachment, error) {
+	attachments := []Attachment{}
+	err := db.Where("post_id = ?", pid).Order("created_at, id").Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func FindAttachment(db *gorm.DB, pid, aid uint64) (*Attachment, error) {
+	attachment := Attachment{}
+	err := db.Where("id = ? and post_id = ?", aid, pid).Take(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Attachment{}, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return &Attachment{}, err
+	}
+	return &attachment, nil
+}
+
+func DeleteAttachment(db *gorm.DB, aid uint64) error {
+	return db.Delete(&Attachment{}, aid).Error
+}
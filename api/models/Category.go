@@ -0,0 +1,187 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryCycle    = errors.New("a category cannot be moved below itself")
+)
+
+// Category is a node of the category tree, posts belong to at most one
+type Category struct {
+	ID        uint64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name      string      `gorm:"size:100;not null" json:"name"`
+	Slug      string      `gorm:"size:120;not null;uniqueIndex" json:"slug"`
+	ParentID  *uint64     `gorm:"index" json:"parent_id"`
+	Children  []*Category `gorm:"-" json:"children,omitempty"`
+	CreatedAt time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time   `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (c *Category) Prepare() {
+	c.ID = 0
+	c.Name = html.EscapeString(strings.Join(strings.Fields(c.Name), " "))
+	c.Slug = utils.Slugify(html.UnescapeString(c.Name))
+	c.Children = nil
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+}
+
+func (c *Category) Validate() error {
+	if c.Name == "" || c.Slug == "" {
+		return errors.New("required name")
+	}
+	return nil
+}
+
+func (c *Category) SaveCategory(db *gorm.DB) (*Category, error) {
+	if c.ParentID != nil {
+		_, err := FindCategoryByID(db, *c.ParentID)
+		if err != nil {
+			return &Category{}, err
+		}
+	}
+
+	err := db.Create(&c).Error
+	if err != nil {
+		return &Category{}, err
+	}
+	return c, nil
+}
+
+// UpdateACategory renames and moves the category, refusing to create a cycle
+func (c *Category) UpdateACategory(db *gorm.DB, id uint64) (*Category, error) {
+	if c.ParentID != nil {
+		descendants, err := CategoryDescendants(db, id)
+		if err != nil {
+			return &Category{}, err
+		}
+		for _, d := range descendants {
+			if d == *c.ParentID {
+				return &Category{}, ErrCategoryCycle
+			}
+		}
+		_, err = FindCategoryByID(db, *c.ParentID)
+		if err != nil {
+			return &Category{}, err
+		}
+	}
+
+	err := db.Model(&Category{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"name":       c.Name,
+		"slug":       c.Slug,
+		"parent_id":  c.ParentID,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		return &Category{}, err
+	}
+	return FindCategoryByID(db, id)
+}
+
+// DeleteACategory hands its children and posts over to its parent
+func DeleteACategory(db *gorm.DB, id uint64) error {
+	category, err := FindCategoryByID(db, id)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Category{}).Where("parent_id = ?", id).UpdateColumn("parent_id", category.ParentID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Post{}).Where("category_id = ?", id).UpdateColumn("category_id", category.ParentID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&Category{}, id).Error
+	})
+}
+
+func FindCategoryByID(db *gorm.DB, id uint64) (*Category, error) {
+	category := Category{}
+	err := db.Model(&Category{}).Where("id = ?", id).Take(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Category{}, ErrCategoryNotFound
+	}
+	if err != nil {
+		return &Category{}, err
+	}
+	return &category, nil
+}
+
+func FindCategoryBySlug(db *gorm.DB, slug string) (*Category, error) {
+	category := Category{}
+	err := db.Model(&Category{}).Where("slug = ?", slug).Take(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Category{}, ErrCategoryNotFound
+	}
+	if err != nil {
+		return &Category{}, err
+	}
+	return &category, nil
+}
+
+// FindCategoryTree returns the root categories with their children nested
+func FindCategoryTree(db *gorm.DB) ([]*Category, error) {
+	categories := []*Category{}
+	err := db.Model(&Category{}).Order("name").Find(&categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[uint64]*Category{}
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+
+	roots := []*Category{}
+	for _, c := range categories {
+		if parent, ok := byID[derefID(c.ParentID)]; ok && c.ParentID != nil {
+			parent.Children = append(parent.Children, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	return roots, nil
+}
+
+// CategoryDescendants returns the category and every category below it
+func CategoryDescendants(db *gorm.DB, id uint64) ([]uint64, error) {
+	ids := []uint64{id}
+	seen := map[uint64]bool{id: true}
+	frontier := []uint64{id}
+	for len(frontier) > 0 {
+		children := []uint64{}
+		err := db.Model(&Category{}).Where("parent_id IN ?", frontier).Pluck("id", &children).Error
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = frontier[:0]
+		for _, child := range children {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+				frontier = append(frontier, child)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func derefID(id *uint64) uint64 {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxCommentDepth is how deep threads may nest and be retrieved
+	MaxCommentDepth     = 10
+	DefaultCommentDepth = 3
+	MaxCommentLength    = 5000
+)
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrCommentTooDeep  = errors.New("replies cannot be nested any deeper")
+)
+
+// Comment belongs to a post, replies point to their parent. Deleted
+// comments that still have replies are kept without content so the thread
+// stays intact.
+type Comment struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	PostID     uint64     `gorm:"not null;index" json:"post_id"`
+	AuthorID   uint32     `gorm:"not null;index" json:"author_id"`
+	Author     User       `json:"author"`
+	ParentID   *uint64    `gorm:"index" json:"parent_id"`
+	Depth      int        `gorm:"not null;default:0" json:"depth"`
+	Content    string     `gorm:"type:text;not null" json:"content"`
+	Deleted    bool       `gorm:"not null;default:false" json:"deleted"`
+	ReplyCount int64      `gorm:"->;-:migration" json:"reply_count"`
+	Replies    []*Comment `gorm:"-" json:"replies,omitempty"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// CommentSorts are the columns top level comments can be listed by
+var CommentSorts = []string{"created_at", "updated_at"}
+
+func (c *Comment) Prepare() {
+	c.ID = 0
+	c.Content = html.EscapeString(strings.TrimSpace(c.Content))
+	c.Author = User{}
+	c.Depth = 0
+	c.Deleted = false
+	c.ReplyCount = 0
+	c.Replies = nil
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+}
+
+func (c *Comment) Validate() error {
+	if c.Content == "" {
+		return errors.New("required content")
+	}
+	if len([]rune(c.Content)) > MaxCommentLength {
+		return errors.New("content must be at most 5000 characters")
+	}
+	if c.AuthorID < 1 {
+		return errors.New("required author")
+	}
+	return nil
+}
+
+// withReplyCount adds the number of direct replies to comment queries
+func withReplyCount(db *gorm.DB) *gorm.DB {
+	return db.Select("comments.*, (SELECT COUNT(*) FROM comments replies WHERE replies.parent_id = comments.id) AS reply_count")
+}
+
+// SaveComment places replies one level below their parent, which must be a
+// comment of the same post
+func (c *Comment) SaveComment(db *gorm.DB) (*Comment, error) {
+	if c.ParentID != nil {
+		parent, err := FindCommentByID(db, c.PostID, *c.ParentID)
+		if err != nil {
+			return &Comment{}, err
+		}
+		if parent.Depth+1 > MaxCommentDepth {
+			return &Comment{}, ErrCommentTooDeep
+		}
+		c.Depth = parent.Depth + 1
+	}
+
+	err := db.Create(&c).Error
+	if err != nil {
+		return &Comment{}, err
+	}
+	return FindCommentByID(db, c.PostID, c.ID)
+}
+
+func FindCommentByID(db *gorm.DB, pid, cid uint64) (*Comment, error) {
+	comment := Comment{}
+	err := withReplyCount(db.Model(&Comment{})).Preload("Author").
+		Where("comments.id = ? and comments.post_id = ?", cid, pid).Take(&comment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Comment{}, ErrCommentNotFound
+	}
+	if err != nil {
+		return &Comment{}, err
+	}
+	return &comment, nil
+}
+
+// FindCommentTree returns the comment with its replies up to depth levels
+// below it
+func FindCommentTree(db *gorm.DB, pid, cid uint64, depth int) (*Comment, error) {
+	comment, err := FindCommentByID(db, pid, cid)
+	if err != nil {
+		return comment, err
+	}
+
+	err = loadReplies(db, []*Comment{comment}, depth)
+	if err != nil {
+		return &Comment{}, err
+	}
+	return comment, nil
+}
+
+// FindPostComments returns a page of top level comments, each with its
+// replies up to depth levels, and the cursor of the next page
+func FindPostComments(db *gorm.DB, pid uint64, page PageQuery, depth int) ([]*Comment, string, error) {
+	query := withReplyCount(db.Model(&Comment{})).Preload("Author").
+		Where("comments.post_id = ? and comments.parent_id is null", pid)
+
+	query, err := page.scope(query, "comments")
+	if err != nil {
+		return nil, "", err
+	}
+
+	comments := []*Comment{}
+	err = query.Find(&comments).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	n, next := page.nextCursor(len(comments), func(i int) (interface{}, uint64) {
+		if page.Sort == "updated_at" {
+			return comments[i].UpdatedAt, comments[i].ID
+		}
+		return comments[i].CreatedAt, comments[i].ID
+	})
+	comments = comments[:n]
+
+	err = loadReplies(db, comments, depth)
+	if err != nil {
+		return nil, "", err
+	}
+	return comments, next, nil
+}
+
+// loadReplies fetches the replies one level at a time, oldest first
+func loadReplies(db *gorm.DB, parents []*Comment, depth int) error {
+	for level := 0; level < depth && len(parents) > 0; level++ {
+		byID := map[uint64]*Comment{}
+		ids := []uint64{}
+		for _, parent := range parents {
+			byID[parent.ID] = parent
+			ids = append(ids, parent.ID)
+		}
+
+		replies := []*Comment{}
+		err := withReplyCount(db.Model(&Comment{})).Preload("Author").
+			Where("comments.parent_id IN ?", ids).
+			Order("comments.created_at, comments.id").Find(&replies).Error
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range replies {
+			parent := byID[*reply.ParentID]
+			parent.Replies = append(parent.Replies, reply)
+		}
+		parents = replies
+	}
+	return nil
+}
+
+func (c *Comment) UpdateAComment(db *gorm.DB) (*Comment, error) {
+	result := db.Model(&Comment{}).Where("id = ? and deleted = ?", c.ID, false).UpdateColumns(
+		map[string]interface{}{
+			"content":    c.Content,
+			"updated_at": time.Now(),
+		},
+	)
+	if result.Error != nil {
+		return &Comment{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &Comment{}, ErrCommentNotFound
+	}
+	return FindCommentByID(db, c.PostID, c.ID)
+}
+
+// DeleteAComment removes the comment, or only its content when there are
+// replies to it
+func DeleteAComment(db *gorm.DB, comment *Comment) error {
+	if comment.ReplyCount == 0 {
+		return db.Delete(&Comment{}, comment.ID).Error
+	}
+
+	return db.Model(&Comment{}).Where("id = ?", comment.ID).UpdateColumns(
+		map[string]interface{}{
+			"content":    "",
+			"deleted":    true,
+			"updated_at": time.Now(),
+		},
+	).Error
+}
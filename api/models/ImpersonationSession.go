@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImpersonationSession is the audit record of an admin acting as a user
+type ImpersonationSession struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	AdminID   uint32     `gorm:"not null;index" json:"admin_id"`
+	TargetID  uint32     `gorm:"not null;index" json:"target_id"`
+	JTI       string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Reason    string     `gorm:"size:255" json:"reason"`
+	IPAddress string     `gorm:"size:45" json:"ip_address"`
+	UserAgent string     `gorm:"size:255" json:"user_agent"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (is *ImpersonationSession) SaveImpersonationSession(db *gorm.DB) (*ImpersonationSession, error) {
+	err := db.Create(&is).Error
+	if err != nil {
+		return &ImpersonationSession{}, err
+	}
+	return is, nil
+}
+
+// FindImpersonationSessions lists the most recent sessions, optionally only
+// those of one admin or one impersonated user
+func FindImpersonationSessions(db *gorm.DB, adminID, targetID uint32) (*[]ImpersonationSession, error) {
+	sessions := []ImpersonationSession{}
+
+	query := db.Model(&ImpersonationSession{})
+	if adminID != 0 {
+		query = query.Where("admin_id = ?", adminID)
+	}
+	if targetID != 0 {
+		query = query.Where("target_id = ?", targetID)
+	}
+
+	err := query.Order("id desc").Limit(100).Find(&sessions).Error
+	if err != nil {
+		return &[]ImpersonationSession{}, err
+	}
+	return &sessions, nil
+}
+
+// EndImpersonationSession records when the session was ended early
+func EndImpersonationSession(db *gorm.DB, jti string) error {
+	return db.Model(&ImpersonationSession{}).
+		Where("jti = ? and ended_at is null", jti).
+		UpdateColumn("ended_at", time.Now()).Error
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttempt counts recent failed sign-ins for a key such as an email
+// address or a client IP.
+type LoginAttempt struct {
+	Identifier    string     `gorm:"primaryKey;size:255" json:"identifier"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// Throttle locks a key out once it reaches Threshold failures, doubling the
+// lockout on every further failure up to MaxDelay. Failures older than
+// Window are forgotten.
+type Throttle struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+// Check returns how long the key is still locked out for
+func (t Throttle) Check(db *gorm.DB, key string) (time.Duration, error) {
+	attempt := LoginAttempt{}
+	err := db.Model(&LoginAttempt{}).Where("identifier = ?", key).Limit(1).Find(&attempt).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if attempt.LockedUntil == nil {
+		return 0, nil
+	}
+	if wait := time.Until(*attempt.LockedUntil); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt and extends the lockout when due
+func (t Throttle) Fail(db *gorm.DB, key string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginAttempt{Identifier: key}).Error
+		if err != nil {
+			return err
+		}
+
+		attempt := LoginAttempt{}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("identifier = ?", key).Take(&attempt).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if now.Sub(attempt.LastFailureAt) > t.Window {
+			attempt.Failures = 0
+			attempt.LockedUntil = nil
+		}
+
+		attempt.Failures++
+		attempt.LastFailureAt = now
+		if attempt.Failures >= t.Threshold {
+			lockedUntil := now.Add(t.delay(attempt.Failures))
+			attempt.LockedUntil = &lockedUntil
+		}
+
+		return tx.Save(&attempt).Error
+	})
+}
+
+func (t Throttle) Reset(db *gorm.DB, key string) error {
+	return db.Where("identifier = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+func (t Throttle) delay(failures int) time.Duration {
+	delay := t.BaseDelay
+	for i := t.Threshold; i < failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay
+}
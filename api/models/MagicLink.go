@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const MagicLinkTTL = time.Minute * 10
+
+var ErrInvalidMagicLink = errors.New("invalid or expired login link")
+
+// MagicLink records a login link sent by email so it can only be used once.
+// The link itself is a signed token carrying JTI.
+type MagicLink struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint32     `gorm:"not null;index" json:"user_id"`
+	JTI       string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// SaveMagicLink stores the link and invalidates the ones sent before
+func (ml *MagicLink) SaveMagicLink(db *gorm.DB) (*MagicLink, error) {
+	err := db.Model(&MagicLink{}).
+		Where("user_id = ? and used_at is null", ml.UserID).
+		UpdateColumn("used_at", time.Now()).Error
+	if err != nil {
+		return &MagicLink{}, err
+	}
+
+	ml.ExpiresAt = time.Now().Add(MagicLinkTTL)
+	err = db.Create(&ml).Error
+	if err != nil {
+		return &MagicLink{}, err
+	}
+	return ml, nil
+}
+
+// ConsumeMagicLink marks the link as used, failing if it is unknown,
+// expired or was already used.
+func ConsumeMagicLink(db *gorm.DB, jti string, uid uint32) error {
+	result := db.Model(&MagicLink{}).
+		Where("jti = ? and user_id = ? and used_at is null and expires_at > ?", jti, uid, time.Now()).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMagicLink
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/password"
+	"gorm.io/gorm"
+)
+
+// PasswordHistory keeps the hashes of replaced passwords so they cannot be
+// chosen again
+type PasswordHistory struct {
+	ID           uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       uint32    `gorm:"not null;index" json:"user_id"`
+	PasswordHash string    `gorm:"size:255;not null" json:"-"`
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// recordPasswordHistory stores the replaced hash and drops the entries the
+// policy no longer looks at
+func recordPasswordHistory(db *gorm.DB, uid uint32, oldHash string) error {
+	keep := password.CurrentPolicy().History
+	if keep <= 1 {
+		return db.Where("user_id = ?", uid).Delete(&PasswordHistory{}).Error
+	}
+
+	err := db.Create(&PasswordHistory{UserID: uid, PasswordHash: oldHash}).Error
+	if err != nil {
+		return err
+	}
+
+	// The current password counts as one of the last N
+	ids := []uint64{}
+	err = db.Model(&PasswordHistory{}).Where("user_id = ?", uid).
+		Order("id desc").Pluck("id", &ids).Error
+	if err != nil || len(ids) < keep {
+		return err
+	}
+	return db.Where("id IN ?", ids[keep-1:]).Delete(&PasswordHistory{}).Error
+}
+
+// CheckPasswordPolicy validates a new password, uid is 0 for users not
+// created yet. Broken rules are returned as a *password.PolicyError.
+func CheckPasswordPolicy(db *gorm.DB, uid uint32, plain string) error {
+	policy := password.CurrentPolicy()
+	violations := policy.Violations(plain)
+
+	if uid != 0 && policy.History > 0 {
+		reused, err := passwordReused(db, uid, plain, policy.History)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, fmt.Sprintf("must not match any of the last %d passwords", policy.History))
+		}
+	}
+
+	if len(violations) > 0 {
+		return &password.PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func passwordReused(db *gorm.DB, uid uint32, plain string, last int) (bool, error) {
+	if IsCurrentPassword(db, uid, plain) {
+		return true, nil
+	}
+	if last <= 1 {
+		return false, nil
+	}
+
+	history := []PasswordHistory{}
+	err := db.Model(&PasswordHistory{}).Where("user_id = ?", uid).
+		Order("id desc").Limit(last - 1).Find(&history).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, h := range history {
+		if VerifyPassword(h.PasswordHash, plain) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsCurrentPassword reports whether plain is the password the user has now
+func IsCurrentPassword(db *gorm.DB, uid uint32, plain string) bool {
+	user := User{}
+	err := db.Model(&User{}).Where("id = ?", uid).Take(&user).Error
+	if err != nil {
+		return false
+	}
+	return VerifyPassword(user.Password, plain) == nil
+}
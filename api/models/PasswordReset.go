@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const PasswordResetTTL = time.Hour * 1
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordReset stores the hash of a single-use password reset token
+type PasswordReset struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint32     `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// SavePasswordReset stores the token and invalidates the ones requested before
+func (pr *PasswordReset) SavePasswordReset(db *gorm.DB) (*PasswordReset, error) {
+	err := db.Model(&PasswordReset{}).
+		Where("user_id = ? and used_at is null", pr.UserID).
+		UpdateColumn("used_at", time.Now()).Error
+	if err != nil {
+		return &PasswordReset{}, err
+	}
+
+	pr.ExpiresAt = time.Now().Add(PasswordResetTTL)
+	err = db.Create(&pr).Error
+	if err != nil {
+		return &PasswordReset{}, err
+	}
+	return pr, nil
+}
+
+// FindPasswordReset returns the reset while it can still be used, without
+// consuming it
+func FindPasswordReset(db *gorm.DB, hash string) (*PasswordReset, error) {
+	pr := PasswordReset{}
+	err := db.Model(&PasswordReset{}).
+		Where("token_hash = ? and used_at is null and expires_at > ?", hash, time.Now()).
+		Take(&pr).Error
+	if err != nil {
+		return &PasswordReset{}, ErrInvalidResetToken
+	}
+	return &pr, nil
+}
+
+// ConsumePasswordReset marks the token as used and returns it, failing if
+// the token is unknown, expired or was already used.
+func ConsumePasswordReset(db *gorm.DB, hash string) (*PasswordReset, error) {
+	pr := PasswordReset{}
+	err := db.Model(&PasswordReset{}).Where("token_hash = ?", hash).Take(&pr).Error
+	if err != nil {
+		return &PasswordReset{}, ErrInvalidResetToken
+	}
+
+	result := db.Model(&PasswordReset{}).
+		Where("id = ? and used_at is null and expires_at > ?", pr.ID, time.Now()).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return &PasswordReset{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &PasswordReset{}, ErrInvalidResetToken
+	}
+
+	return &pr, nil
+}
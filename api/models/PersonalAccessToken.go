@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"gorm.io/gorm"
+)
+
+// PersonalAccessToken is a long lived credential for scripts, only its hash
+// is stored and the token itself is shown once on creation.
+type PersonalAccessToken struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     uint32     `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Prefix     string     `gorm:"size:12;not null" json:"prefix"`
+	Scopes     StringList `gorm:"size:255;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (t *PersonalAccessToken) Prepare() {
+	t.ID = 0
+	t.Name = strings.TrimSpace(t.Name)
+	t.LastUsedAt = nil
+	t.RevokedAt = nil
+	t.CreatedAt = time.Now()
+}
+
+func (t *PersonalAccessToken) Validate() error {
+	if t.Name == "" {
+		return errors.New("required name")
+	}
+	if len(t.Scopes) == 0 {
+		return errors.New("required scopes")
+	}
+	for _, scope := range t.Scopes {
+		if !auth.IsValidScope(scope) {
+			return errors.New("invalid scope " + scope)
+		}
+	}
+	if t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now()) {
+		return errors.New("expiry must be in the future")
+	}
+	return nil
+}
+
+func (t *PersonalAccessToken) SavePersonalAccessToken(db *gorm.DB) (*PersonalAccessToken, error) {
+	err := db.Create(&t).Error
+	if err != nil {
+		return &PersonalAccessToken{}, err
+	}
+	return t, nil
+}
+
+func (t *PersonalAccessToken) FindUserPersonalAccessTokens(db *gorm.DB, uid uint32) (*[]PersonalAccessToken, error) {
+	tokens := []PersonalAccessToken{}
+	err := db.Model(&PersonalAccessToken{}).Where("user_id = ?", uid).Order("id").Find(&tokens).Error
+	if err != nil {
+		return &[]PersonalAccessToken{}, err
+	}
+	return &tokens, nil
+}
+
+func (t *PersonalAccessToken) RevokePersonalAccessToken(db *gorm.DB, id uint64, uid uint32) error {
+	db = db.Model(&PersonalAccessToken{}).
+		Where("id = ? and user_id = ? and revoked_at is null", id, uid).
+		UpdateColumn("revoked_at", time.Now())
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+// PersonalAccessTokens implements auth.PersonalAccessTokenStore
+type PersonalAccessTokens struct {
+	DB *gorm.DB
+}
+
+func (s PersonalAccessTokens) LookupPersonalAccessToken(hash string) (*auth.TokenIdentity, error) {
+	token := PersonalAccessToken{}
+	err := s.DB.Model(&PersonalAccessToken{}).
+		Where("token_hash = ? and revoked_at is null and (expires_at is null or expires_at > ?)", hash, time.Now()).
+		Take(&token).Error
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	user := User{}
+	err = s.DB.Model(&User{}).Where("id = ?", token.UserID).Take(&user).Error
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	// Avoid a write on every request, a minute precision is plenty
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > time.Minute {
+		s.DB.Model(&PersonalAccessToken{}).Where("id = ?", token.ID).UpdateColumn("last_used_at", time.Now())
+	}
+
+	return &auth.TokenIdentity{
+		TokenID: token.ID,
+		UserID:  token.UserID,
+		Role:    user.Role,
+		Scopes:  token.Scopes,
+	}, nil
+}
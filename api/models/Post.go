@@ -1,34 +1,89 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"html"
 	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Only published posts are public, the others are seen by their author and
+// moderators. Scheduled posts are published at PublishAt by the scheduler.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+	PostArchived  = "archived"
+)
+
+var PostStatuses = []string{PostDraft, PostScheduled, PostPublished, PostArchived}
+
+const MaxPostContentLength = 100000
+
 type Post struct {
 	gorm.Model
-	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
-	Title     string    `gorm:"size:255;not null;unique" json:"title"`
-	Content   string    `gorm:"size:255;not null" json:"content"`
-	Author    User      `json:"author"`
-	AuthorID  uint32    `gorm:"foreignKey;not null" json:"author_id"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID    uint64 `gorm:"primaryKey;autoIncrement" json:"id"`
+	Title string `gorm:"size:255;not null;unique" json:"title"`
+	// Slug follows the title, PostSlug keeps the ones it had before
+	Slug string `gorm:"size:255;index" json:"slug"`
+	// Content is markdown, ContentHTML its rendering from the cache of the
+	// current revision
+	Content     string    `gorm:"type:text;not null" json:"content_markdown"`
+	ContentHTML string    `gorm:"-" json:"content_html"`
+	Revision    int       `gorm:"not null;default:0" json:"revision"`
+	Author      User      `json:"author"`
+	AuthorID    uint32    `gorm:"foreignKey;not null" json:"author_id"`
+	Tags        []Tag     `gorm:"many2many:post_tags" json:"tags"`
+	CategoryID  *uint64   `gorm:"index" json:"category_id"`
+	Category    *Category `json:"category,omitempty"`
+	// Posts from before statuses existed stay published
+	Status      string     `gorm:"size:20;not null;default:published;index" json:"status"`
+	PublishAt   *time.Time `gorm:"index" json:"publish_at"`
+	PublishedAt *time.Time `json:"published_at"`
+	// CommentCount is filled in when the post is read
+	CommentCount int64     `gorm:"->;-:migration" json:"comment_count"`
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
 func (p *Post) Prepare() {
 	p.ID = 0
 	p.Title = html.EscapeString(strings.TrimSpace(p.Title))
-	p.Content = html.EscapeString(strings.TrimSpace(p.Content))
+	p.Slug = ""
+	p.Content = strings.TrimSpace(p.Content)
+	p.ContentHTML = ""
+	p.Revision = 0
 	p.Author = User{}
+	p.Category = nil
+	p.PublishedAt = nil
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = time.Now()
 }
 
+// UnmarshalJSON takes the markdown as content_markdown, or as content like
+// before posts were written in markdown
+func (p *Post) UnmarshalJSON(data []byte) error {
+	type post Post
+	legacy := struct {
+		*post
+		Content *string `json:"content"`
+	}{post: (*post)(p)}
+
+	err := json.Unmarshal(data, &legacy)
+	if err != nil {
+		return err
+	}
+	if p.Content == "" && legacy.Content != nil {
+		p.Content = *legacy.Content
+	}
+	return nil
+}
+
 func (p *Post) Validate() error {
 	if p.Title == "" {
 		return errors.New("required title")
@@ -36,17 +91,75 @@ func (p *Post) Validate() error {
 	if p.Content == "" {
 		return errors.New("required content")
 	}
+	if len([]rune(p.Content)) > MaxPostContentLength {
+		return fmt.Errorf("content must be at most %d characters", MaxPostContentLength)
+	}
 	if p.AuthorID < 1 {
 		return errors.New("required author")
 	}
 	return nil
 }
 
+func (p *Post) IsPublished() bool {
+	return p.Status == PostPublished
+}
+
+// SetStatus moves the post to status. Scheduled posts need a publish_at in
+// the future, published ones keep the time they were first published.
+func (p *Post) SetStatus(status string, publishAt *time.Time) error {
+	now := time.Now()
+	switch status {
+	case PostDraft, PostArchived:
+		p.PublishAt = nil
+	case PostPublished:
+		p.PublishAt = nil
+		if p.PublishedAt == nil {
+			p.PublishedAt = &now
+		}
+	case PostScheduled:
+		if publishAt == nil {
+			return errors.New("required publish_at")
+		}
+		if !publishAt.After(now) {
+			return errors.New("publish_at must be in the future")
+		}
+		p.PublishAt = publishAt
+	default:
+		return fmt.Errorf("invalid status, use one of %s", strings.Join(PostStatuses, ", "))
+	}
+	p.Status = status
+	return nil
+}
+
+// SavePost expects the tags to be resolved with ResolveTags already. The
+// post starts with its first revision.
 func (p *Post) SavePost(db *gorm.DB) (*Post, error) {
-	err := db.Model(&Post{}).Create(&p).Error
+	revision := PostRevision{
+		Number:    1,
+		Title:     p.Title,
+		Content:   p.Content,
+		EditorID:  p.AuthorID,
+		Changes:   StringList{"title", "content"},
+		CreatedAt: p.CreatedAt,
+	}
+	p.Revision = revision.Number
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Post{}).Create(&p).Error
+		if err != nil {
+			return err
+		}
+		err = assignPostSlug(tx, p)
+		if err != nil {
+			return err
+		}
+		revision.PostID = p.ID
+		return tx.Create(&revision).Error
+	})
 	if err != nil {
 		return &Post{}, err
 	}
+	p.ContentHTML = revision.ContentHTML
 
 	if p.ID != 0 {
 		err := db.Model(&User{}).Where("id = ?", p.AuthorID).Take(&p.Author).Error
@@ -58,26 +171,76 @@ func (p *Post) SavePost(db *gorm.DB) (*Post, error) {
 	return p, nil
 }
 
-func (p *Post) FindAllPosts(db *gorm.DB) (*[]Post, error) {
+// withCommentCount adds the number of comments, not counting deleted ones,
+// to post queries
+func withCommentCount(db *gorm.DB) *gorm.DB {
+	return db.Select("posts.*, (SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted = false) AS comment_count")
+}
+
+// PostSorts are the columns posts can be listed by
+var PostSorts = []string{"created_at", "updated_at", "title"}
+
+// FindAllPosts returns one page of posts and the cursor of the next one
+func (p *Post) FindAllPosts(db *gorm.DB, page PageQuery) (*[]Post, string, error) {
+	query := withCommentCount(db.Model(&Post{}))
+	if page.AuthorID != 0 {
+		query = query.Where("posts.author_id = ?", page.AuthorID)
+	}
+	if page.Tag != "" {
+		query = query.Where("posts.id IN (?)", db.Table("post_tags").
+			Select("post_tags.post_id").
+			Joins("JOIN tags ON tags.id = post_tags.tag_id").
+			Where("tags.slug = ?", page.Tag))
+	}
+	if page.CategoryIDs != nil {
+		query = query.Where("posts.category_id IN ?", page.CategoryIDs)
+	}
+	if page.Status != "" {
+		query = query.Where("posts.status = ?", page.Status)
+	}
+
+	query, err := page.scope(query, "posts")
+	if err != nil {
+		return &[]Post{}, "", err
+	}
+
 	posts := []Post{}
-	err := db.Model(&Post{}).Limit(100).Find(&posts).Error
+	err = query.Preload("Tags").Preload("Category").Find(&posts).Error
 	if err != nil {
-		return &[]Post{}, err
+		return &[]Post{}, "", err
 	}
 
-	if len(posts) > 0 {
-		for _, i := range posts {
-			err := db.Model(&User{}).Where("id = ?", i.AuthorID).Take(&i.Author).Error
-			if err != nil {
-				return &[]Post{}, err
-			}
+	n, next := page.nextCursor(len(posts), func(i int) (interface{}, uint64) {
+		return posts[i].sortValue(page.Sort), posts[i].ID
+	})
+	posts = posts[:n]
+
+	err = renderPosts(db, postRefs(posts)...)
+	if err != nil {
+		return &[]Post{}, "", err
+	}
+
+	for i := range posts {
+		err := db.Model(&User{}).Where("id = ?", posts[i].AuthorID).Take(&posts[i].Author).Error
+		if err != nil {
+			return &[]Post{}, "", err
 		}
 	}
-	return &posts, nil
+	return &posts, next, nil
+}
+
+func (p *Post) sortValue(column string) interface{} {
+	switch column {
+	case "updated_at":
+		return p.UpdatedAt
+	case "title":
+		return p.Title
+	}
+	return p.CreatedAt
 }
 
 func (p *Post) FindPostByID(db *gorm.DB, pid uint64) (*Post, error) {
-	err := db.Model(&Post{}).Where("id = ?", pid).Take(&p).Error
+	err := withCommentCount(db.Model(&Post{})).Preload("Tags").Preload("Category").Where("posts.id = ?", pid).Take(&p).Error
 	if err != nil {
 		return &Post{}, err
 	}
@@ -88,29 +251,111 @@ func (p *Post) FindPostByID(db *gorm.DB, pid uint64) (*Post, error) {
 		}
 	}
 
+	err = renderPosts(db, p)
+	if err != nil {
+		return &Post{}, err
+	}
+
 	return p, nil
 }
 
-func (p *Post) UpdateAPost(db *gorm.DB) (*Post, error) {
-	db = db.Model(&Post{}).Where("id = ?", p.ID).Take(&p).Updates(
-		Post{
-			Title:     p.Title,
-			Content:   p.Content,
-			UpdatedAt: time.Now(),
-		},
-	)
-	if db.Error != nil {
-		return &Post{}, db.Error
-	}
+// UpdateAPost replaces the tags only when some were sent, resolved with
+// ResolveTags already. Edits of the title or content are kept as revisions
+// of editorID.
+func (p *Post) UpdateAPost(db *gorm.DB, editorID uint32) (*Post, error) {
+	return p.update(db, editorID, nil)
+}
 
-	if p.ID != 0 {
-		err := db.Model(&User{}).Where("id = ?", p.AuthorID).Take(&p.Author).Error
+func (p *Post) update(db *gorm.DB, editorID uint32, restoredFrom *int) (*Post, error) {
+	title, content, categoryID, tags := p.Title, p.Content, p.CategoryID, p.Tags
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current := Post{}
+		err := tx.Model(&Post{}).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", p.ID).Take(&current).Error
 		if err != nil {
-			return &Post{}, err
+			return err
 		}
+
+		err = tx.Model(&Post{}).Where("id = ?", p.ID).Updates(
+			map[string]interface{}{
+				"title":        title,
+				"content":      content,
+				"category_id":  categoryID,
+				"status":       p.Status,
+				"publish_at":   p.PublishAt,
+				"published_at": p.PublishedAt,
+				"updated_at":   time.Now(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+
+		if tags != nil {
+			err = SetPostTags(tx, p, tags)
+			if err != nil {
+				return err
+			}
+		}
+
+		if title != current.Title || current.Slug == "" {
+			err = assignPostSlug(tx, p)
+			if err != nil {
+				return err
+			}
+		}
+
+		return recordPostRevision(tx, &current, title, content, editorID, restoredFrom)
+	})
+	if err != nil {
+		return &Post{}, err
 	}
 
-	return p, nil
+	return p.FindPostByID(db, p.ID)
+}
+
+// UpdatePostStatus saves the status set with SetStatus
+func (p *Post) UpdatePostStatus(db *gorm.DB) (*Post, error) {
+	err := db.Model(&Post{}).Where("id = ?", p.ID).UpdateColumns(
+		map[string]interface{}{
+			"status":       p.Status,
+			"publish_at":   p.PublishAt,
+			"published_at": p.PublishedAt,
+			"updated_at":   time.Now(),
+		},
+	).Error
+	if err != nil {
+		return &Post{}, err
+	}
+	return p.FindPostByID(db, p.ID)
+}
+
+// PublishDuePosts publishes the scheduled posts whose time has come and
+// returns their IDs. Rows being published by another server are skipped.
+func PublishDuePosts(db *gorm.DB, now time.Time) ([]uint64, error) {
+	ids := []uint64{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Post{}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? and publish_at <= ?", PostScheduled, now).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		return tx.Model(&Post{}).Where("id IN ?", ids).UpdateColumns(
+			map[string]interface{}{
+				"status":       PostPublished,
+				"published_at": gorm.Expr("publish_at"),
+				"publish_at":   nil,
+				"updated_at":   now,
+			},
+		).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
 func (p *Post) DeleteAPost(db *gorm.DB, pid uint64, uid uint32) (int64, error) {
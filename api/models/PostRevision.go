@@ -0,0 +1,213 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/markdown"
+	"gorm.io/gorm"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// PostRevision is the title and content of a post after one of its edits.
+// Revisions are never changed, restoring one adds a new revision. Only the
+// cached HTML is rendered again when the markdown renderer changes.
+type PostRevision struct {
+	ID            uint64 `gorm:"primaryKey;autoIncrement" json:"id"`
+	PostID        uint64 `gorm:"not null;uniqueIndex:idx_post_revision" json:"post_id"`
+	Number        int    `gorm:"not null;uniqueIndex:idx_post_revision" json:"number"`
+	Title         string `gorm:"size:255;not null" json:"title"`
+	Content       string `gorm:"type:text;not null" json:"content_markdown"`
+	ContentHTML   string `gorm:"type:text" json:"content_html,omitempty"`
+	RenderVersion int    `gorm:"not null;default:0" json:"-"`
+	EditorID      uint32 `gorm:"not null" json:"editor_id"`
+	// Changes names the fields that differ from the previous revision
+	Changes      StringList `gorm:"size:255" json:"changes"`
+	RestoredFrom *int       `json:"restored_from"`
+	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// BeforeCreate renders the content once for all reads of the revision
+func (r *PostRevision) BeforeCreate(tx *gorm.DB) error {
+	html, err := markdown.Render(r.Content)
+	if err != nil {
+		return err
+	}
+	r.ContentHTML, r.RenderVersion = html, markdown.Version
+	return nil
+}
+
+// refreshHTML renders the content again when it was cached by an older
+// renderer
+func (r *PostRevision) refreshHTML(db *gorm.DB) error {
+	if r.RenderVersion == markdown.Version {
+		return nil
+	}
+
+	html, err := markdown.Render(r.Content)
+	if err != nil {
+		return err
+	}
+	r.ContentHTML, r.RenderVersion = html, markdown.Version
+
+	return db.Model(&PostRevision{}).Where("id = ?", r.ID).UpdateColumns(
+		map[string]interface{}{
+			"content_html":   r.ContentHTML,
+			"render_version": r.RenderVersion,
+		},
+	).Error
+}
+
+// renderPosts fills in the HTML of the posts from their current revision.
+// Posts from before revisions existed are rendered on every read.
+func renderPosts(db *gorm.DB, posts ...*Post) error {
+	keys := [][]interface{}{}
+	for _, p := range posts {
+		if p.Revision > 0 {
+			keys = append(keys, []interface{}{p.ID, p.Revision})
+		}
+	}
+
+	revisions := []PostRevision{}
+	if len(keys) > 0 {
+		err := db.Where("(post_id, number) IN ?", keys).Find(&revisions).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	byPost := map[uint64]*PostRevision{}
+	for i := range revisions {
+		byPost[revisions[i].PostID] = &revisions[i]
+	}
+
+	for _, p := range posts {
+		revision, ok := byPost[p.ID]
+		if !ok {
+			html, err := markdown.Render(p.Content)
+			if err != nil {
+				return err
+			}
+			p.ContentHTML = html
+			continue
+		}
+
+		err := revision.refreshHTML(db)
+		if err != nil {
+			return err
+		}
+		p.ContentHTML = revision.ContentHTML
+	}
+	return nil
+}
+
+func postRefs(posts []Post) []*Post {
+	refs := make([]*Post, len(posts))
+	for i := range posts {
+		refs[i] = &posts[i]
+	}
+	return refs
+}
+
+// RevisionSorts are the columns revisions can be listed by
+var RevisionSorts = []string{"created_at"}
+
+// recordPostRevision adds a revision when the title or content of current
+// changed. Posts from before revisions existed get their original state
+// recorded first.
+func recordPostRevision(tx *gorm.DB, current *Post, title, content string, editorID uint32, restoredFrom *int) error {
+	changes := StringList{}
+	if title != current.Title {
+		changes = append(changes, "title")
+	}
+	if content != current.Content {
+		changes = append(changes, "content")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	last := PostRevision{}
+	err := tx.Where("post_id = ?", current.ID).Order("number desc").Take(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		last = PostRevision{
+			PostID:    current.ID,
+			Number:    1,
+			Title:     current.Title,
+			Content:   current.Content,
+			EditorID:  current.AuthorID,
+			Changes:   StringList{"title", "content"},
+			CreatedAt: current.UpdatedAt,
+		}
+		err = tx.Create(&last).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	revision := PostRevision{
+		PostID:       current.ID,
+		Number:       last.Number + 1,
+		Title:        title,
+		Content:      content,
+		EditorID:     editorID,
+		Changes:      changes,
+		RestoredFrom: restoredFrom,
+		CreatedAt:    time.Now(),
+	}
+	err = tx.Create(&revision).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&Post{}).Where("id = ?", current.ID).UpdateColumn("revision", revision.Number).Error
+}
+
+// FindPostRevisions returns one page of revisions, without their content
+func FindPostRevisions(db *gorm.DB, pid uint64, page PageQuery) ([]PostRevision, string, error) {
+	query, err := page.scope(db.Model(&PostRevision{}).Omit("content", "content_html").Where("post_revisions.post_id = ?", pid), "post_revisions")
+	if err != nil {
+		return nil, "", err
+	}
+
+	revisions := []PostRevision{}
+	err = query.Find(&revisions).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	n, next := page.nextCursor(len(revisions), func(i int) (interface{}, uint64) {
+		return revisions[i].CreatedAt, revisions[i].ID
+	})
+	return revisions[:n], next, nil
+}
+
+func FindPostRevision(db *gorm.DB, pid uint64, number int) (*PostRevision, error) {
+	revision := PostRevision{}
+	err := db.Where("post_id = ? and number = ?", pid, number).Take(&revision).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &PostRevision{}, ErrRevisionNotFound
+	}
+	if err != nil {
+		return &PostRevision{}, err
+	}
+
+	err = revision.refreshHTML(db)
+	if err != nil {
+		return &PostRevision{}, err
+	}
+	return &revision, nil
+}
+
+// RestorePostRevision puts the title and content of the revision back,
+// which is recorded as a new revision
+func RestorePostRevision(db *gorm.DB, revision *PostRevision, editorID uint32) (*Post, error) {
+	post := Post{}
+	_, err := post.FindPostByID(db, revision.PostID)
+	if err != nil {
+		return &Post{}, err
+	}
+
+	post.Title, post.Content, post.Tags = revision.Title, revision.Content, nil
+	return post.update(db, editorID, &revision.Number)
+}
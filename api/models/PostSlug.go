@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/utils"
+	"gorm.io/gorm"
+)
+
+const maxPostSlugLength = 100
+
+var ErrPostSlugNotFound = errors.New("post not found")
+
+// PostSlug is every slug a post was given, the current one included, so old
+// links keep working after the title changes. A slug is never given to
+// another post.
+type PostSlug struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	PostID    uint64    `gorm:"not null;index" json:"post_id"`
+	Slug      string    `gorm:"size:255;not null;uniqueIndex" json:"slug"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// postSlugBase is the slug of a title before any collision suffix
+func postSlugBase(title string) string {
+	slug := utils.Slugify(html.UnescapeString(title))
+	if runes := []rune(slug); len(runes) > maxPostSlugLength {
+		slug = strings.TrimRight(string(runes[:maxPostSlugLength]), "-")
+	}
+	if slug == "" {
+		return "post"
+	}
+	return slug
+}
+
+// assignPostSlug gives the post the slug of its title, adding -2, -3... when
+// another post has or had it. A slug the post had before is taken back.
+func assignPostSlug(tx *gorm.DB, p *Post) error {
+	base := postSlugBase(p.Title)
+
+	taken := []PostSlug{}
+	err := tx.Where("slug = ? OR slug LIKE ?", base, base+"-%").Find(&taken).Error
+	if err != nil {
+		return err
+	}
+	owners := map[string]uint64{}
+	for _, s := range taken {
+		owners[s.Slug] = s.PostID
+	}
+
+	slug := base
+	for n := 2; ; n++ {
+		owner, ok := owners[slug]
+		if !ok {
+			err = tx.Create(&PostSlug{PostID: p.ID, Slug: slug, CreatedAt: time.Now()}).Error
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if owner == p.ID {
+			break
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	p.Slug = slug
+	return tx.Model(&Post{}).Where("id = ?", p.ID).UpdateColumn("slug", slug).Error
+}
+
+// FindPostSlug returns who has or had the slug
+func FindPostSlug(db *gorm.DB, slug string) (*PostSlug, error) {
+	postSlug := PostSlug{}
+	err := db.Where("slug = ?", slug).Take(&postSlug).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &PostSlug{}, ErrPostSlugNotFound
+	}
+	if err != nil {
+		return &PostSlug{}, err
+	}
+	return &postSlug, nil
+}
+
+// BackfillPostSlugs gives a slug to the posts from before slugs existed
+func BackfillPostSlugs(db *gorm.DB) error {
+	posts := []Post{}
+	err := db.Model(&Post{}).Where("slug IS NULL OR slug = ?", "").Order("id").Find(&posts).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range posts {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return assignPostSlug(tx, &posts[i])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a hashed one-time code to sign in without the authenticator
+type RecoveryCode struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint32     `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// ReplaceRecoveryCodes drops the previous codes of the user and stores the new ones
+func ReplaceRecoveryCodes(db *gorm.DB, uid uint32, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+
+		codes := make([]RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = RecoveryCode{UserID: uid, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode consumes the code, returning false if it is unknown or used
+func UseRecoveryCode(db *gorm.DB, uid uint32, hash string) (bool, error) {
+	db = db.Model(&RecoveryCode{}).
+		Where("user_id = ? and code_hash = ? and used_at is null", uid, hash).
+		UpdateColumn("used_at", time.Now())
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected > 0, nil
+}
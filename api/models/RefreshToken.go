@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshToken is a single-use token; every refresh consumes it and issues
+// a new one in the same family.
+type RefreshToken struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	FamilyID  string     `gorm:"size:64;not null;index" json:"family_id"`
+	UserID    uint32     `gorm:"not null;index" json:"user_id"`
+	Scopes    StringList `gorm:"size:255" json:"scopes"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (rt *RefreshToken) SaveRefreshToken(db *gorm.DB) (*RefreshToken, error) {
+	err := db.Create(&rt).Error
+	if err != nil {
+		return &RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+func (rt *RefreshToken) FindRefreshTokenByHash(db *gorm.DB, hash string) (*RefreshToken, error) {
+	err := db.Model(&RefreshToken{}).Where("token_hash = ?", hash).Take(&rt).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &RefreshToken{}, errors.New("refresh token not found")
+		}
+		return &RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+// Consume marks the token as used. Presenting a token that was already used
+// or revoked is treated as theft and the whole family is revoked.
+func (rt *RefreshToken) Consume(db *gorm.DB) error {
+	if rt.ExpiresAt.Before(time.Now()) {
+		return errors.New("refresh token expired")
+	}
+
+	result := db.Model(&RefreshToken{}).
+		Where("id = ? and used_at is null and revoked_at is null", rt.ID).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		err := RevokeRefreshTokenFamily(db, rt.FamilyID)
+		if err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+func RevokeRefreshTokenFamily(db *gorm.DB, familyID string) error {
+	err := db.Model(&RefreshToken{}).
+		Where("family_id = ? and revoked_at is null", familyID).
+		UpdateColumn("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return revokeSessions(db, "family_id = ?", familyID)
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RevokedToken is an access token denylisted before its expiry
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:64" json:"jti"`
+	UserID    uint32    `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (rt *RevokedToken) SaveRevokedToken(db *gorm.DB) error {
+	// Expired entries no longer need to be checked
+	err := db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Where(RevokedToken{JTI: rt.JTI}).FirstOrCreate(&rt).Error
+}
+
+// Denylist implements auth.Denylist on top of the revoked_tokens table
+type Denylist struct {
+	DB *gorm.DB
+}
+
+func (d Denylist) IsRevoked(jti string, userID uint32, issuedAt time.Time) (bool, error) {
+
+	var count int64
+	if jti != "" {
+		err := d.DB.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	// Tokens issued up to the second all sessions were revoked are rejected
+	err := d.DB.Model(&User{}).
+		Where("id = ? and tokens_revoked_at >= ?", userID, issuedAt).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeUserTokens invalidates every access and refresh token of the user
+func RevokeUserTokens(db *gorm.DB, uid uint32) error {
+	err := db.Model(&User{}).Where("id = ?", uid).
+		UpdateColumn("tokens_revoked_at", time.Now().Truncate(time.Second)).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&RefreshToken{}).
+		Where("user_id = ? and revoked_at is null", uid).
+		UpdateColumn("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return revokeSessions(db, "user_id = ?", uid)
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxTagsPerPost = 10
+	MaxTagLength   = 50
+)
+
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("a tag with this name exists, merge the tags instead")
+)
+
+// Tag is attached to posts through the post_tags table. Names are case
+// folded and the slug is unique, so "Go" and "go " are the same tag.
+type Tag struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name      string    `gorm:"size:50;not null" json:"name"`
+	Slug      string    `gorm:"size:60;not null;uniqueIndex" json:"slug"`
+	PostCount int64     `gorm:"->;-:migration" json:"post_count,omitempty"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// UnmarshalJSON lets posts be sent with tags as plain names
+func (t *Tag) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*t = Tag{Name: name}
+		return nil
+	}
+
+	type tag Tag
+	return json.Unmarshal(data, (*tag)(t))
+}
+
+// NormalizeTag folds the case and whitespace of a tag name and returns it
+// with its slug
+func NormalizeTag(name string) (string, string) {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return name, utils.Slugify(name)
+}
+
+// ResolveTags returns the tags with the given names, creating the missing
+// ones. Duplicates after normalization are dropped.
+func ResolveTags(db *gorm.DB, names []string) ([]Tag, error) {
+	tags := []Tag{}
+	seen := map[string]bool{}
+	for _, raw := range names {
+		name, slug := NormalizeTag(raw)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		if len(name) > MaxTagLength {
+			return nil, errors.New("tags must be at most 50 characters")
+		}
+		seen[slug] = true
+		tags = append(tags, Tag{Name: name, Slug: slug})
+	}
+	if len(tags) > MaxTagsPerPost {
+		return nil, errors.New("too many tags, at most 10 are allowed")
+	}
+
+	for i := range tags {
+		err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags[i]).Error
+		if err != nil {
+			return nil, err
+		}
+		err = db.Model(&Tag{}).Where("slug = ?", tags[i].Slug).Take(&tags[i]).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// TagNames returns the names of tags sent with a post
+func TagNames(tags []Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
+
+// SetPostTags replaces the tags of the post
+func SetPostTags(db *gorm.DB, post *Post, tags []Tag) error {
+	return db.Model(post).Association("Tags").Replace(tags)
+}
+
+// FindAllTags lists the tags with the number of published posts using them,
+// most used first
+func FindAllTags(db *gorm.DB) (*[]Tag, error) {
+	tags := []Tag{}
+	err := db.Model(&Tag{}).
+		Select("tags.*, COUNT(posts.id) AS post_count").
+		Joins("LEFT JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("LEFT JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL AND posts.status = ?", PostPublished).
+		Group("tags.id").
+		Order("post_count desc, tags.name").
+		Find(&tags).Error
+	if err != nil {
+		return &[]Tag{}, err
+	}
+	return &tags, nil
+}
+
+func FindTagBySlug(db *gorm.DB, slug string) (*Tag, error) {
+	tag := Tag{}
+	err := db.Model(&Tag{}).Where("slug = ?", slug).Take(&tag).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Tag{}, ErrTagNotFound
+	}
+	if err != nil {
+		return &Tag{}, err
+	}
+	return &tag, nil
+}
+
+// RenameTag changes the name, and with it the slug, of a tag
+func RenameTag(db *gorm.DB, slug, newName string) (*Tag, error) {
+	tag, err := FindTagBySlug(db, slug)
+	if err != nil {
+		return tag, err
+	}
+
+	name, newSlug := NormalizeTag(newName)
+	if newSlug == "" {
+		return &Tag{}, errors.New("required name")
+	}
+	if len(name) > MaxTagLength {
+		return &Tag{}, errors.New("tags must be at most 50 characters")
+	}
+
+	if newSlug != tag.Slug {
+		_, err = FindTagBySlug(db, newSlug)
+		if err == nil {
+			return &Tag{}, ErrTagExists
+		}
+		if !errors.Is(err, ErrTagNotFound) {
+			return &Tag{}, err
+		}
+	}
+
+	err = db.Model(&Tag{}).Where("id = ?", tag.ID).UpdateColumns(map[string]interface{}{
+		"name":       name,
+		"slug":       newSlug,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		return &Tag{}, err
+	}
+
+	tag.Name, tag.Slug = name, newSlug
+	return tag, nil
+}
+
+// MergeTags moves the posts of the source tag to the target and deletes the
+// source
+func MergeTags(db *gorm.DB, sourceSlug, targetSlug string) (*Tag, error) {
+	source, err := FindTagBySlug(db, sourceSlug)
+	if err != nil {
+		return &Tag{}, err
+	}
+	target, err := FindTagBySlug(db, targetSlug)
+	if err != nil {
+		return &Tag{}, err
+	}
+	if source.ID == target.ID {
+		return &Tag{}, errors.New("cannot merge a tag into itself")
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Posts tagged with both keep a single link
+		err := tx.Exec(`INSERT INTO post_tags (post_id, tag_id)
+			SELECT post_id, ? FROM post_tags WHERE tag_id = ?
+			ON CONFLICT DO NOTHING`, target.ID, source.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM post_tags WHERE tag_id = ?", source.ID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&Tag{}, source.ID).Error
+	})
+	if err != nil {
+		return &Tag{}, err
+	}
+	return target, nil
+}
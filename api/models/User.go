@@ -2,34 +2,49 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"html"
 	"strings"
 	"time"
 
 	"github.com/badoux/checkmail"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/mvr-garcia/fullgo/api/password"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type User struct {
 	gorm.Model
-	ID        uint32    `gorm:"primaryKey;autoIncrement" json:"id"`
-	Nickname  string    `gorm:"size:255;not null;unique" json:"nickname"`
-	Email     string    `gorm:"size:100;not null;unique" json:"email"`
-	Password  string    `gorm:"size:100;not null" json:"password"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID                 uint32     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Nickname           string     `gorm:"size:255;not null;unique" json:"nickname"`
+	Email              string     `gorm:"size:100;not null;unique" json:"email"`
+	Password           string     `gorm:"size:255;not null" json:"password"`
+	Role               string     `gorm:"size:20;not null;default:user" json:"role"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	VerificationSentAt *time.Time `json:"-"`
+	TokensRevokedAt    *time.Time `json:"-"`
+	TOTPSecret         string     `gorm:"size:64" json:"-"`
+	TOTPEnabled        bool       `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastStep       int64      `gorm:"not null;default:0" json:"-"`
+	AvatarKey          string     `gorm:"size:255" json:"-"`
+	AvatarURL          string     `gorm:"-" json:"avatar_url,omitempty"`
+	CreatedAt          time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
-func GenerateHash(password string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func GenerateHash(plain string) ([]byte, error) {
+	hashedPassword, err := password.Hash(plain)
+	return []byte(hashedPassword), err
 }
 
-func VerifyPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// VerifyPassword accepts hashes from any supported algorithm and returns
+// password.ErrMismatch when the password is wrong
+func VerifyPassword(hashedPassword, plain string) error {
+	return password.Verify(hashedPassword, plain)
 }
 
-func (u *User) BeforeSave(tx *gorm.DB) error {
+// HashPassword replaces the plain text password with its hash
+func (u *User) HashPassword() error {
 	hashedPassword, err := GenerateHash(u.Password)
 	if err != nil {
 		return err
@@ -39,10 +54,21 @@ func (u *User) BeforeSave(tx *gorm.DB) error {
 	return nil
 }
 
+// AfterFind points avatar_url at the endpoint that redirects to a signed
+// link, which stays valid as long as the avatar does
+func (u *User) AfterFind(tx *gorm.DB) error {
+	if u.AvatarKey != "" {
+		u.AvatarURL = fmt.Sprintf("/users/%d/avatar", u.ID)
+	}
+	return nil
+}
+
 func (u *User) Prepare() {
 	u.ID = 0
 	u.Nickname = html.EscapeString(strings.TrimSpace(u.Nickname))
 	u.Email = html.EscapeString(strings.TrimSpace(u.Email))
+	u.Role = "" // Roles are only granted through UpdateUserRole
+	u.EmailVerifiedAt = nil
 	u.CreatedAt = time.Now()
 	u.UpdatedAt = time.Now()
 }
@@ -90,20 +116,49 @@ func (u *User) Validate(action string) error {
 }
 
 func (u *User) SaveUser(db *gorm.DB) (*User, error) {
-	err := db.Create(&u).Error
+	err := u.HashPassword()
+	if err != nil {
+		return &User{}, err
+	}
+
+	err = db.Create(&u).Error
 	if err != nil {
 		return &User{}, err
 	}
 	return u, nil
 }
 
-func (u *User) FindAllUsers(db *gorm.DB) (*[]User, error) {
+// UserSorts are the columns users can be listed by
+var UserSorts = []string{"created_at", "updated_at", "nickname"}
+
+// FindAllUsers returns one page of users and the cursor of the next one
+func (u *User) FindAllUsers(db *gorm.DB, page PageQuery) (*[]User, string, error) {
+	query, err := page.scope(db.Model(&User{}), "users")
+	if err != nil {
+		return &[]User{}, "", err
+	}
+
 	users := []User{}
-	err := db.Model(&User{}).Limit(100).Find(&users).Error
+	err = query.Find(&users).Error
 	if err != nil {
-		return &[]User{}, err
+		return &[]User{}, "", err
+	}
+
+	n, next := page.nextCursor(len(users), func(i int) (interface{}, uint64) {
+		return users[i].sortValue(page.Sort), uint64(users[i].ID)
+	})
+	users = users[:n]
+	return &users, next, nil
+}
+
+func (u *User) sortValue(column string) interface{} {
+	switch column {
+	case "updated_at":
+		return u.UpdatedAt
+	case "nickname":
+		return u.Nickname
 	}
-	return &users, nil
+	return u.CreatedAt
 }
 
 func (u *User) FindUserByID(db *gorm.DB, uid uint32) (*User, error) {
@@ -120,26 +175,207 @@ func (u *User) FindUserByID(db *gorm.DB, uid uint32) (*User, error) {
 
 func (u *User) UpdateAUser(db *gorm.DB, uid uint32) (*User, error) {
 
-	// Generate hash from password
-	err := u.BeforeSave(db)
+	current := User{}
+	err := db.Model(User{}).Where("id = ?", uid).Take(&current).Error
 	if err != nil {
 		return &User{}, err
 	}
 
-	db = db.Model(User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+	// Sending the current password again keeps the stored hash
+	if VerifyPassword(current.Password, u.Password) == nil {
+		u.Password = current.Password
+	} else {
+		err = u.HashPassword()
+		if err != nil {
+			return &User{}, err
+		}
+		err = recordPasswordHistory(db, uid, current.Password)
+		if err != nil {
+			return &User{}, err
+		}
+	}
+
+	columns := map[string]interface{}{
+		"password":   u.Password,
+		"nickname":   u.Nickname,
+		"email":      u.Email,
+		"updated_at": time.Now(),
+	}
+
+	// A new email address has to be verified again
+	if current.Email != u.Email {
+		columns["email_verified_at"] = nil
+	}
+
+	db = db.Model(User{}).Where("id = ?", uid).UpdateColumns(columns)
+
+	if db.Error != nil {
+		return &User{}, db.Error
+	}
+
+	// Get the updated user
+	err = db.Model(&User{}).Where("id = ?", uid).Take(&u).Error
+	if err != nil {
+		return &User{}, err
+	}
+
+	return u, nil
+}
+
+func (u *User) UpdatePassword(db *gorm.DB, uid uint32, plain string) error {
+	current := User{}
+	err := db.Model(User{}).Where("id = ?", uid).Take(&current).Error
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := GenerateHash(plain)
+	if err != nil {
+		return err
+	}
+
+	err = recordPasswordHistory(db, uid, current.Password)
+	if err != nil {
+		return err
+	}
+
+	return db.Model(User{}).Where("id = ?", uid).UpdateColumns(
 		map[string]interface{}{
-			"password":   u.Password,
-			"nickname":   u.Nickname,
-			"email":      u.Email,
+			"password":   string(hashedPassword),
 			"updated_at": time.Now(),
 		},
-	)
+	).Error
+}
 
+// RehashPassword stores a new hash of an already verified password, used to
+// move users to the current algorithm without touching their sessions
+func (u *User) RehashPassword(db *gorm.DB, plain string) error {
+	hashedPassword, err := GenerateHash(plain)
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(User{}).Where("id = ? AND password = ?", u.ID, u.Password).UpdateColumn("password", string(hashedPassword)).Error
+	if err != nil {
+		return err
+	}
+
+	u.Password = string(hashedPassword)
+	return nil
+}
+
+// VerifyEmail marks the email as verified, as long as it did not change
+// since the verification link was sent.
+func (u *User) VerifyEmail(db *gorm.DB, uid uint32, email string) error {
+	db = db.Model(User{}).
+		Where("id = ? and email = ?", uid, email).
+		UpdateColumn("email_verified_at", time.Now())
 	if db.Error != nil {
-		return &User{}, db.Error
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("invalid verification link")
+	}
+	return nil
+}
+
+// ReserveVerificationEmail records that a verification email is being sent,
+// returning false if the previous one was sent less than interval ago.
+func (u *User) ReserveVerificationEmail(db *gorm.DB, uid uint32, interval time.Duration) (bool, error) {
+	db = db.Model(User{}).
+		Where("id = ? and (verification_sent_at is null or verification_sent_at < ?)", uid, time.Now().Add(-interval)).
+		UpdateColumn("verification_sent_at", time.Now())
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected > 0, nil
+}
+
+// SetTOTPSecret stores a new secret that is not used until EnableTOTP
+func (u *User) SetTOTPSecret(db *gorm.DB, uid uint32, secret string) error {
+	return db.Model(User{}).Where("id = ? and totp_enabled = ?", uid, false).UpdateColumns(
+		map[string]interface{}{
+			"totp_secret":    secret,
+			"totp_last_step": 0,
+		},
+	).Error
+}
+
+// SetAvatar stores the key of the new avatar, empty to remove it, and
+// returns the key of the one it replaces
+func (u *User) SetAvatar(db *gorm.DB, uid uint32, key string) (string, error) {
+	previous := ""
+	err := db.Transaction(func(tx *gorm.DB) error {
+		user := User{}
+		err := tx.Model(User{}).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", uid).Take(&user).Error
+		if err != nil {
+			return err
+		}
+		previous = user.AvatarKey
+		return tx.Model(User{}).Where("id = ?", uid).UpdateColumn("avatar_key", key).Error
+	})
+	return previous, err
+}
+
+func (u *User) EnableTOTP(db *gorm.DB, uid uint32) error {
+	return db.Model(User{}).Where("id = ?", uid).UpdateColumn("totp_enabled", true).Error
+}
+
+func (u *User) DisableTOTP(db *gorm.DB, uid uint32) error {
+	err := db.Model(User{}).Where("id = ?", uid).UpdateColumns(
+		map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		},
+	).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+}
+
+// UseTOTPStep records step as used, returning false if it or a later step
+// was already used so codes cannot be replayed.
+func (u *User) UseTOTPStep(db *gorm.DB, uid uint32, step int64) (bool, error) {
+	db = db.Model(User{}).
+		Where("id = ? and totp_last_step < ?", uid, step).
+		UpdateColumn("totp_last_step", step)
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected > 0, nil
+}
+
+// UpdateUserRole revokes the tokens of the user when the role changes, since
+// they carry the old role and the scopes it allowed
+func (u *User) UpdateUserRole(db *gorm.DB, uid uint32, role string) (*User, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current := User{}
+		err := tx.Model(User{}).Where("id = ?", uid).Take(&current).Error
+		if err != nil {
+			return err
+		}
+		if current.Role == role {
+			return nil
+		}
+
+		err = tx.Model(User{}).Where("id = ?", uid).UpdateColumns(
+			map[string]interface{}{
+				"role":       role,
+				"updated_at": time.Now(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+		return RevokeUserTokens(tx, uid)
+	})
+	if err != nil {
+		return &User{}, err
 	}
 
-	// Get the updated user
 	err = db.Model(&User{}).Where("id = ?", uid).Take(&u).Error
 	if err != nil {
 		return &User{}, err
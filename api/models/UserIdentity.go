@@ -0,0 +1,124 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links an account at an external OpenID Connect provider to a user
+type UserIdentity struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Provider  string    `gorm:"size:50;not null;uniqueIndex:idx_identity_provider_subject" json:"provider"`
+	Subject   string    `gorm:"size:255;not null;uniqueIndex:idx_identity_provider_subject" json:"subject"`
+	UserID    uint32    `gorm:"not null;index" json:"user_id"`
+	Email     string    `gorm:"size:100" json:"email"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// LinkExternalIdentity returns the user linked to the external identity.
+// Unknown identities are linked to the user with the same email when both the
+// provider and the user verified it, otherwise a new user is created. An
+// unverified account may have been registered by someone other than the owner
+// of the address, so it is not taken over.
+func LinkExternalIdentity(db *gorm.DB, provider, subject, email string, emailVerified bool, name string) (*User, error) {
+
+	user := User{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+
+		identity := UserIdentity{}
+		err := tx.Model(&UserIdentity{}).Where("provider = ? and subject = ?", provider, subject).Take(&identity).Error
+		if err == nil {
+			return tx.Model(&User{}).Where("id = ?", identity.UserID).Take(&user).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		email = strings.TrimSpace(email)
+		if email == "" {
+			return errors.New("provider did not return an email")
+		}
+
+		err = tx.Model(&User{}).Where("email = ?", email).Take(&user).Error
+		switch {
+		case err == nil && !emailVerified:
+			return errors.New("email already taken")
+		case err == nil && user.EmailVerifiedAt == nil:
+			return errors.New("verify your email before signing in with this provider")
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user, err = newExternalUser(tx, email, emailVerified, name)
+			if err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+
+		return tx.Create(&UserIdentity{
+			Provider: provider,
+			Subject:  subject,
+			UserID:   user.ID,
+			Email:    email,
+		}).Error
+	})
+	if err != nil {
+		return &User{}, err
+	}
+
+	return &user, nil
+}
+
+func newExternalUser(tx *gorm.DB, email string, emailVerified bool, name string) (User, error) {
+
+	// The account can only be used through the provider until a password is reset
+	password, err := auth.RandomToken(32)
+	if err != nil {
+		return User{}, err
+	}
+
+	nickname, err := uniqueNickname(tx, name, email)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Nickname: nickname, Email: email, Password: password}
+	err = user.HashPassword()
+	if err != nil {
+		return User{}, err
+	}
+	if emailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	err = tx.Create(&user).Error
+	return user, err
+}
+
+func uniqueNickname(tx *gorm.DB, name, email string) (string, error) {
+
+	base := html.EscapeString(strings.TrimSpace(name))
+	if base == "" {
+		base = strings.Split(email, "@")[0]
+	}
+
+	nickname := base
+	for i := 2; i < 100; i++ {
+		var count int64
+		err := tx.Model(&User{}).Where("nickname = ?", nickname).Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return nickname, nil
+		}
+		nickname = fmt.Sprintf("%s %d", base, i)
+	}
+	return "", errors.New("nickname already taken")
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mvr-garcia/fullgo/api/auth"
+	"gorm.io/gorm"
+)
+
+// lastSeenResolution limits how often using a token writes to the database
+const lastSeenResolution = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// UserSession is one login of a user. FamilyID is the refresh token family,
+// so the session lives on through refreshes while JTI follows the current
+// access token.
+type UserSession struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     uint32     `gorm:"not null;index" json:"user_id"`
+	FamilyID   string     `gorm:"size:64;not null;index" json:"-"`
+	JTI        string     `gorm:"size:64;not null;index" json:"-"`
+	UserAgent  string     `gorm:"size:255" json:"user_agent"`
+	IPAddress  string     `gorm:"size:45" json:"ip_address"`
+	IssuedAt   time.Time  `gorm:"not null" json:"issued_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	// Set when listed with the token of this very session
+	Current bool `gorm:"-" json:"current"`
+}
+
+// FindUserSessions lists the sessions that are still usable
+func FindUserSessions(db *gorm.DB, uid uint32) (*[]UserSession, error) {
+	sessions := []UserSession{}
+	err := db.Model(&UserSession{}).
+		Where("user_id = ? and revoked_at is null and expires_at > ?", uid, time.Now()).
+		Order("last_seen_at desc").Find(&sessions).Error
+	if err != nil {
+		return &[]UserSession{}, err
+	}
+	return &sessions, nil
+}
+
+// RevokeUserSession ends the session: its refresh tokens stop working and
+// the current access token is denied until it expires.
+func RevokeUserSession(db *gorm.DB, uid uint32, sid uint64) error {
+	us := UserSession{}
+	err := db.Model(&UserSession{}).
+		Where("id = ? and user_id = ? and revoked_at is null", sid, uid).
+		Take(&us).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+
+	err = RevokeRefreshTokenFamily(db, us.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	revoked := RevokedToken{
+		JTI:       us.JTI,
+		UserID:    us.UserID,
+		ExpiresAt: us.IssuedAt.Add(auth.AccessTokenTTL),
+	}
+	return revoked.SaveRevokedToken(db)
+}
+
+// EndUserSession ends the session the access token belongs to, including
+// its refresh tokens
+func EndUserSession(db *gorm.DB, jti string) error {
+	us := UserSession{}
+	err := db.Model(&UserSession{}).Where("jti = ? and revoked_at is null", jti).Take(&us).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return RevokeRefreshTokenFamily(db, us.FamilyID)
+}
+
+// SessionTracker implements auth.SessionTracker on the user_sessions table
+type SessionTracker struct {
+	DB *gorm.DB
+}
+
+func (t SessionTracker) TrackToken(claims *auth.Claims, client auth.ClientInfo) error {
+	now := time.Now()
+	columns := map[string]interface{}{
+		"jti":          claims.Id,
+		"user_agent":   truncateColumn(client.UserAgent, 255),
+		"ip_address":   client.IP,
+		"issued_at":    claims.IssuedAtTime(),
+		"last_seen_at": now,
+		"expires_at":   now.Add(auth.RefreshTokenTTL),
+	}
+
+	// A refresh continues the session of its family
+	if client.SessionID != "" {
+		result := t.DB.Model(&UserSession{}).
+			Where("family_id = ? and user_id = ? and revoked_at is null", client.SessionID, claims.UserID).
+			UpdateColumns(columns)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+	}
+
+	return t.DB.Create(&UserSession{
+		UserID:     claims.UserID,
+		FamilyID:   client.SessionID,
+		JTI:        claims.Id,
+		UserAgent:  truncateColumn(client.UserAgent, 255),
+		IPAddress:  client.IP,
+		IssuedAt:   claims.IssuedAtTime(),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(auth.RefreshTokenTTL),
+	}).Error
+}
+
+func (t SessionTracker) TouchToken(claims *auth.Claims) error {
+	now := time.Now()
+	return t.DB.Model(&UserSession{}).
+		Where("jti = ? and last_seen_at < ?", claims.Id, now.Add(-lastSeenResolution)).
+		UpdateColumn("last_seen_at", now).Error
+}
+
+// revokeSessions marks the sessions as ended, called whenever their
+// refresh tokens are revoked
+func revokeSessions(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Model(&UserSession{}).
+		Where(query+" and revoked_at is null", args...).
+		UpdateColumn("revoked_at", time.Now()).Error
+}
+
+func truncateColumn(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
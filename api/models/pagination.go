@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageQuery selects one page of a listing. Pages are keyset based: the
+// cursor holds the sort value and ID of the last row of the previous page,
+// so rows inserted meanwhile do not shift the pages.
+type PageQuery struct {
+	Limit         int
+	After         *Cursor
+	Sort          string
+	Desc          bool
+	AuthorID      uint32
+	Tag           string
+	CategoryIDs   []uint64
+	Status        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// Cursor is handed to clients as an opaque string
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    uint64 `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &Cursor{}
+	err = json.Unmarshal(data, c)
+	if err != nil || c.Sort == "" {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// isTimeColumn tells how cursor values of the sort column are compared
+func isTimeColumn(column string) bool {
+	return strings.HasSuffix(column, "_at")
+}
+
+// formatSortValue turns the sort value of a row into its cursor form
+func formatSortValue(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+// scope applies the filters, the order and the cursor to a query on table.
+// One row more than the limit is fetched to know whether a next page exists.
+func (q PageQuery) scope(db *gorm.DB, table string) (*gorm.DB, error) {
+
+	if q.CreatedAfter != nil {
+		db = db.Where(table+".created_at >= ?", *q.CreatedAfter)
+	}
+	if q.CreatedBefore != nil {
+		db = db.Where(table+".created_at < ?", *q.CreatedBefore)
+	}
+
+	column := table + "." + q.Sort
+	op, dir := ">", "asc"
+	if q.Desc {
+		op, dir = "<", "desc"
+	}
+
+	if q.After != nil {
+		if q.After.Sort != q.Sort || q.After.Desc != q.Desc {
+			return nil, ErrInvalidCursor
+		}
+
+		var value interface{} = q.After.Value
+		if isTimeColumn(q.Sort) {
+			t, err := time.Parse(time.RFC3339Nano, q.After.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			value = t
+		}
+
+		db = db.Where(
+			fmt.Sprintf("%s %s ? or (%s = ? and %s.id %s ?)", column, op, column, table, op),
+			value, value, q.After.ID,
+		)
+	}
+
+	return db.Order(column + " " + dir).Order(table + ".id " + dir).Limit(q.Limit + 1), nil
+}
+
+// nextCursor trims the extra row fetched by scope and returns the cursor of
+// the following page, empty on the last page
+func (q PageQuery) nextCursor(count int, last func(i int) (interface{}, uint64)) (int, string) {
+	if count <= q.Limit {
+		return count, ""
+	}
+
+	value, id := last(q.Limit - 1)
+	return q.Limit, Cursor{Sort: q.Sort, Desc: q.Desc, Value: formatSortValue(value), ID: id}.Encode()
+}
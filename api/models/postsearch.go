@@ -0,0 +1,243 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// maxSearchClauses bounds the work a single query can cause
+const maxSearchClauses = 10
+
+// SearchHit is a post matching a search, best matches first
+type SearchHit struct {
+	ID      uint64
+	Rank    float64
+	Snippet string
+}
+
+// PostSearchIndex finds posts by their title and content. Postgres keeps
+// its index in the posts table, other databases use MemoryPostSearch.
+type PostSearchIndex interface {
+	IndexPost(p *Post) error
+	RemovePost(id uint64) error
+	// SearchPosts returns up to limit hits after skipping offset, and
+	// whether more hits follow
+	SearchPosts(query string, limit, offset int) ([]SearchHit, bool, error)
+}
+
+// PostSearchResult is a post with how well it matched
+type PostSearchResult struct {
+	Post
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// NewPostSearchIndex picks the index for the database, POST_SEARCH=memory
+// forces the in-process index
+func NewPostSearchIndex(db *gorm.DB) (PostSearchIndex, error) {
+	if db.Dialector.Name() == "postgres" && os.Getenv("POST_SEARCH") != "memory" {
+		err := MigratePostSearch(db)
+		if err != nil {
+			return nil, err
+		}
+		return PostgresPostSearch{DB: db}, nil
+	}
+	return NewMemoryPostSearch(db), nil
+}
+
+// FindSearchResults loads the posts of the hits, keeping their order
+func FindSearchResults(db *gorm.DB, hits []SearchHit) (*[]PostSearchResult, error) {
+	results := []PostSearchResult{}
+	if len(hits) == 0 {
+		return &results, nil
+	}
+
+	ids := make([]uint64, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+
+	posts := []Post{}
+	err := withCommentCount(db.Model(&Post{})).Preload("Tags").Preload("Category").Where("posts.id IN ?", ids).Find(&posts).Error
+	if err != nil {
+		return &results, err
+	}
+
+	err = renderPosts(db, postRefs(posts)...)
+	if err != nil {
+		return &results, err
+	}
+
+	byID := map[uint64]Post{}
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+
+	for _, hit := range hits {
+		post, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		err = db.Model(&User{}).Where("id = ?", post.AuthorID).Take(&post.Author).Error
+		if err != nil {
+			return &results, err
+		}
+		results = append(results, PostSearchResult{Post: post, Rank: hit.Rank, Snippet: hit.Snippet})
+	}
+	return &results, nil
+}
+
+// searchClause is one word, a prefix (word*) or a "quoted phrase". A
+// phrase may end in a prefix too.
+type searchClause struct {
+	Terms  []string
+	Prefix bool
+}
+
+// parseSearchQuery splits a user query into clauses that must all match.
+// Anything but letters and digits is dropped, so the result is safe to
+// build a tsquery from.
+func parseSearchQuery(query string) []searchClause {
+	clauses := []searchClause{}
+
+	// Words joined by punctuation, like e-mail, end up as a phrase too
+	add := func(text string) {
+		terms := searchTerms(text)
+		if len(terms) > 0 {
+			clauses = append(clauses, searchClause{Terms: terms, Prefix: strings.HasSuffix(text, "*")})
+		}
+	}
+
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			add(part)
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			add(word)
+		}
+	}
+
+	if len(clauses) > maxSearchClauses {
+		clauses = clauses[:maxSearchClauses]
+	}
+	return clauses
+}
+
+// searchTerms lowercases text and splits it into words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// PostgresPostSearch uses the weighted tsvector column of the posts table
+type PostgresPostSearch struct {
+	DB *gorm.DB
+}
+
+// searchConfig is the text search configuration, POST_SEARCH_CONFIG
+// defaults to english
+func searchConfig() string {
+	config := os.Getenv("POST_SEARCH_CONFIG")
+	if config == "" {
+		return "english"
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, config)
+}
+
+// MigratePostSearch adds the generated search column, title weighted above
+// content, and its GIN index. Nothing is done outside of Postgres.
+func MigratePostSearch(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	config := searchConfig()
+	err := db.Exec(fmt.Sprintf(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('%[1]s', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('%[1]s', coalesce(content, '')), 'B')
+	) STORED`, config)).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_posts_search ON posts USING GIN (search)").Error
+}
+
+// The generated column follows every write
+func (s PostgresPostSearch) IndexPost(p *Post) error {
+	return nil
+}
+
+func (s PostgresPostSearch) RemovePost(id uint64) error {
+	return nil
+}
+
+func (s PostgresPostSearch) SearchPosts(query string, limit, offset int) ([]SearchHit, bool, error) {
+	tsquery := buildTSQuery(parseSearchQuery(query))
+	if tsquery == "" {
+		return []SearchHit{}, false, nil
+	}
+
+	config := searchConfig()
+	rows := []struct {
+		ID      uint64
+		Rank    float64
+		Snippet string
+	}{}
+	err := s.DB.Raw(fmt.Sprintf(`SELECT posts.id, ts_rank_cd(posts.search, query) AS rank,
+		ts_headline('%[1]s', posts.content, query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2') AS snippet
+		FROM posts, to_tsquery('%[1]s', ?) query
+		WHERE posts.search @@ query AND posts.deleted_at IS NULL AND posts.status = '%[2]s'
+		ORDER BY rank DESC, posts.id DESC
+		LIMIT ? OFFSET ?`, config, PostPublished), tsquery, limit+1, offset).Scan(&rows).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, row := range rows {
+		hits = append(hits, SearchHit{ID: row.ID, Rank: row.Rank, Snippet: escapeHeadline(row.Snippet)})
+	}
+	if len(hits) > limit {
+		return hits[:limit], true, nil
+	}
+	return hits, false, nil
+}
+
+// escapeHeadline escapes the raw post content ts_headline returns, keeping
+// only the <mark> tags around the matches
+func escapeHeadline(snippet string) string {
+	return strings.NewReplacer(
+		"&lt;mark&gt;", "<mark>",
+		"&lt;/mark&gt;", "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// buildTSQuery turns the clauses into a to_tsquery expression: phrases use
+// the followed-by operator and prefixes the :* suffix
+func buildTSQuery(clauses []searchClause) string {
+	parts := []string{}
+	for _, clause := range clauses {
+		terms := make([]string, len(clause.Terms))
+		for i, term := range clause.Terms {
+			terms[i] = "'" + term + "'"
+		}
+		if clause.Prefix {
+			terms[len(terms)-1] += ":*"
+		}
+		parts = append(parts, "("+strings.Join(terms, " <-> ")+")")
+	}
+	return strings.Join(parts, " & ")
+}
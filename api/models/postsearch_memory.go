@@ -0,0 +1,282 @@
+package models
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Title matches count more than content matches, like the A and B weights
+// of the Postgres index
+const (
+	titleWeight   = 1.0
+	contentWeight = 0.4
+	snippetWords  = 10
+)
+
+// MemoryPostSearch is an in-process inverted index for databases without
+// full-text search. It is filled from the database on first use and kept
+// current through IndexPost and RemovePost.
+type MemoryPostSearch struct {
+	db       *gorm.DB
+	loadOnce sync.Once
+	loadErr  error
+
+	mu    sync.RWMutex
+	docs  map[uint64]*searchDoc
+	terms map[string]map[uint64]struct{}
+}
+
+type searchDoc struct {
+	title   []searchToken
+	content []searchToken
+	text    string
+}
+
+// searchToken is a word and where it is in the original text
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+func NewMemoryPostSearch(db *gorm.DB) *MemoryPostSearch {
+	return &MemoryPostSearch{
+		db:    db,
+		docs:  map[uint64]*searchDoc{},
+		terms: map[string]map[uint64]struct{}{},
+	}
+}
+
+func (s *MemoryPostSearch) load() error {
+	s.loadOnce.Do(func() {
+		if s.db == nil {
+			return
+		}
+
+		posts := []Post{}
+		s.loadErr = s.db.Model(&Post{}).Where("status = ?", PostPublished).Find(&posts).Error
+		for i := range posts {
+			s.IndexPost(&posts[i])
+		}
+	})
+	return s.loadErr
+}
+
+// IndexPost only keeps published posts, others are dropped from the index
+func (s *MemoryPostSearch) IndexPost(p *Post) error {
+	if !p.IsPublished() {
+		return s.RemovePost(p.ID)
+	}
+
+	doc := &searchDoc{
+		title:   tokenize(html.UnescapeString(p.Title)),
+		content: tokenize(html.UnescapeString(p.Content)),
+		text:    html.UnescapeString(p.Content),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(p.ID)
+	s.docs[p.ID] = doc
+	for _, tokens := range [][]searchToken{doc.title, doc.content} {
+		for _, token := range tokens {
+			if s.terms[token.term] == nil {
+				s.terms[token.term] = map[uint64]struct{}{}
+			}
+			s.terms[token.term][p.ID] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryPostSearch) RemovePost(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+	return nil
+}
+
+// remove expects the lock to be held
+func (s *MemoryPostSearch) remove(id uint64) {
+	doc, ok := s.docs[id]
+	if !ok {
+		return
+	}
+	for _, tokens := range [][]searchToken{doc.title, doc.content} {
+		for _, token := range tokens {
+			delete(s.terms[token.term], id)
+			if len(s.terms[token.term]) == 0 {
+				delete(s.terms, token.term)
+			}
+		}
+	}
+	delete(s.docs, id)
+}
+
+func (s *MemoryPostSearch) SearchPosts(query string, limit, offset int) ([]SearchHit, bool, error) {
+	err := s.load()
+	if err != nil {
+		return nil, false, err
+	}
+
+	clauses := parseSearchQuery(query)
+	if len(clauses) == 0 {
+		return []SearchHit{}, false, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Only documents holding a term of the first clause can match at all
+	hits := []SearchHit{}
+	for id := range s.candidates(clauses[0]) {
+		doc := s.docs[id]
+		rank := 0.0
+		for _, clause := range clauses {
+			titleMatches := matchClause(doc.title, clause)
+			contentMatches := matchClause(doc.content, clause)
+			if len(titleMatches)+len(contentMatches) == 0 {
+				rank = 0
+				break
+			}
+			rank += titleWeight*float64(len(titleMatches)) + contentWeight*float64(len(contentMatches))
+		}
+		if rank == 0 {
+			continue
+		}
+
+		// Longer posts mention everything more often
+		rank /= 1 + float64(len(doc.content))/100
+		hits = append(hits, SearchHit{ID: id, Rank: rank, Snippet: doc.snippet(clauses)})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].ID > hits[j].ID
+	})
+
+	if offset >= len(hits) {
+		return []SearchHit{}, false, nil
+	}
+	hits = hits[offset:]
+	if len(hits) > limit {
+		return hits[:limit], true, nil
+	}
+	return hits, false, nil
+}
+
+// candidates returns the documents containing the first term of the clause
+func (s *MemoryPostSearch) candidates(clause searchClause) map[uint64]struct{} {
+	first := clause.Terms[0]
+	if !(clause.Prefix && len(clause.Terms) == 1) {
+		return s.terms[first]
+	}
+
+	ids := map[uint64]struct{}{}
+	for term, docs := range s.terms {
+		if strings.HasPrefix(term, first) {
+			for id := range docs {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+	return ids
+}
+
+// matchClause returns the positions where the clause starts in tokens
+func matchClause(tokens []searchToken, clause searchClause) []int {
+	matches := []int{}
+	last := len(clause.Terms) - 1
+	for i := 0; i+last < len(tokens); i++ {
+		matched := true
+		for j, term := range clause.Terms {
+			token := tokens[i+j].term
+			if j == last && clause.Prefix {
+				matched = strings.HasPrefix(token, term)
+			} else {
+				matched = token == term
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// snippet shows the content around the first match with every matching
+// word wrapped in <mark> and the rest of the text escaped
+func (d *searchDoc) snippet(clauses []searchClause) string {
+	if len(d.content) == 0 {
+		return ""
+	}
+
+	marked := map[int]bool{}
+	first := -1
+	for _, clause := range clauses {
+		for _, pos := range matchClause(d.content, clause) {
+			for j := range clause.Terms {
+				marked[pos+j] = true
+			}
+			if first == -1 || pos < first {
+				first = pos
+			}
+		}
+	}
+	if first == -1 {
+		first = 0
+	}
+
+	from := first - snippetWords/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords*2
+	if to > len(d.content) {
+		to = len(d.content)
+	}
+
+	var b strings.Builder
+	for i := from; i < to; i++ {
+		token := d.content[i]
+		if i > from {
+			b.WriteString(html.EscapeString(d.text[d.content[i-1].end:token.start]))
+		}
+		word := html.EscapeString(d.text[token.start:token.end])
+		if marked[i] {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// tokenize splits text into lowercase words, keeping their offsets
+func tokenize(text string) []searchToken {
+	tokens := []searchToken{}
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start == -1 {
+			start = i
+		}
+		if !isWord && start != -1 {
+			tokens = append(tokens, searchToken{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, searchToken{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
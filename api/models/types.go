@@ -0,0 +1,35 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// StringList is stored as a comma separated column and serialized as a JSON array
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		s = ""
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+
+	*l = StringList{}
+	if s == "" {
+		return nil
+	}
+	*l = strings.Split(s, ",")
+	return nil
+}
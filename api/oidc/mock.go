@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mvr-garcia/fullgo/api/auth"
+)
+
+// MockProvider is a minimal OpenID Connect provider that approves every
+// authorization request, so the login flow can run offline. The user can
+// be picked with the login_hint parameter and defaults to mock@example.com.
+type MockProvider struct {
+	Issuer   string
+	ClientID string
+
+	keys  *auth.KeyManager
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	email       string
+	nonce       string
+	challenge   string
+	redirectURI string
+	expiresAt   time.Time
+}
+
+func NewMockProvider(issuer, clientID string) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MockProvider{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientID: clientID,
+		keys: auth.NewKeyManager(&auth.SigningKey{
+			ID:      "mock",
+			Method:  jwt.SigningMethodRS256,
+			Private: key,
+			Public:  &key.PublicKey,
+		}),
+		codes: map[string]mockGrant{},
+	}, nil
+}
+
+// ServeHTTP expects the provider prefix to be stripped from the path
+func (m *MockProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, Discovery{
+			Issuer:                m.Issuer,
+			AuthorizationEndpoint: m.Issuer + "/authorize",
+			TokenEndpoint:         m.Issuer + "/token",
+			JWKSURI:               m.Issuer + "/jwks",
+		})
+	case "/jwks":
+		writeJSON(w, http.StatusOK, m.keys.JWKS())
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *MockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != m.ClientID || q.Get("code_challenge_method") != "S256" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	email := q.Get("login_hint")
+	if email == "" {
+		email = "mock@example.com"
+	}
+
+	code, err := auth.RandomToken(16)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	m.mu.Lock()
+	m.codes[code] = mockGrant{
+		email:       email,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: redirectURI.String(),
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (m *MockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	grant, ok := m.codes[code]
+	delete(m.codes, code)
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || time.Now().After(grant.expiresAt) ||
+		r.PostForm.Get("client_id") != m.ClientID ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := m.keys.Sign(jwt.MapClaims{
+		"iss":            m.Issuer,
+		"aud":            m.ClientID,
+		"sub":            "mock|" + grant.email,
+		"email":          grant.email,
+		"email_verified": true,
+		"name":           strings.Split(grant.email, "@")[0],
+		"nonce":          grant.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute * 5).Unix(),
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": idToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,283 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mvr-garcia/fullgo/api/auth"
+)
+
+var httpClient = &http.Client{Timeout: time.Second * 10}
+
+// Provider is an OpenID Connect provider used with the authorization code
+// flow and PKCE. Its endpoints are discovered on first use.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      map[string]auth.JWK
+}
+
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity is the verified subset of the ID token claims
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// ProvidersFromEnv reads OIDC_PROVIDERS (comma separated names) and for each
+// name the OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL
+// variables.
+func ProvidersFromEnv() map[string]*Provider {
+	providers := map[string]*Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers[name] = &Provider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+	}
+	return providers
+}
+
+// NewPKCE returns a code verifier and its S256 challenge
+func NewPKCE() (string, string, error) {
+	verifier, err := auth.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (p *Provider) Discover() (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := Discovery{}
+	err := getJSON(p.Issuer+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: %q", d.Issuer)
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *Provider) AuthCodeURL(state, nonce, challenge string) (string, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + v.Encode(), nil
+}
+
+// Exchange redeems the authorization code and verifies the returned ID token
+func (p *Provider) Exchange(code, verifier, nonce string) (*Identity, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	res, err := httpClient.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", res.Status)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("no id_token in token response")
+	}
+
+	return p.VerifyIDToken(tokens.IDToken, nonce)
+}
+
+func (p *Provider) VerifyIDToken(idToken, nonce string) (*Identity, error) {
+	token, err := jwt.Parse(idToken, p.keyfunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid id_token")
+	}
+	if claims["iss"] != p.Issuer {
+		return nil, errors.New("id_token issuer mismatch")
+	}
+	if !hasAudience(claims["aud"], p.ClientID) {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	identity := Identity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("id_token without subject")
+	}
+	return &identity, nil
+}
+
+func (p *Provider) keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, ok := p.cachedKey(kid)
+	if !ok {
+		// The provider may have rotated its keys
+		err := p.refreshKeys()
+		if err != nil {
+			return nil, err
+		}
+		key, ok = p.cachedKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+	}
+
+	public, method, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if t.Method.Alg() != method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return public, nil
+}
+
+func (p *Provider) cachedKey(kid string) (auth.JWK, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) refreshKeys() error {
+	d, err := p.Discover()
+	if err != nil {
+		return err
+	}
+
+	set := auth.JWKSet{}
+	err = getJSON(d.JWKSURI, &set)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]auth.JWK{}
+	for _, key := range set.Keys {
+		keys[key.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getJSON(url string, v interface{}) error {
+	res, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,143 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id hashes into the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2id struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// NewArgon2id uses the RFC 9106 recommended parameters for constrained memory
+func NewArgon2id() *Argon2id {
+	return &Argon2id{
+		Memory:  64 * 1024,
+		Time:    3,
+		Threads: 2,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+// argon2Limits are the largest parameters a stored hash may ask for:
+// ARGON2_MAX_MEMORY (KiB, 1 GiB by default), ARGON2_MAX_TIME and
+// ARGON2_MAX_THREADS (16 by default). They never go below the parameters new
+// hashes are created with.
+func argon2Limits() Argon2id {
+	configured := NewArgon2id()
+	configured.Memory = uint32(envInt("ARGON2_MEMORY", int(configured.Memory)))
+	configured.Time = uint32(envInt("ARGON2_TIME", int(configured.Time)))
+	configured.Threads = uint8(envInt("ARGON2_THREADS", int(configured.Threads)))
+
+	limits := Argon2id{
+		Memory:  uint32(envInt("ARGON2_MAX_MEMORY", 1024*1024)),
+		Time:    uint32(envInt("ARGON2_MAX_TIME", 16)),
+		Threads: uint8(envInt("ARGON2_MAX_THREADS", 16)),
+		KeyLen:  128,
+	}
+	if configured.Memory > limits.Memory {
+		limits.Memory = configured.Memory
+	}
+	if configured.Time > limits.Time {
+		limits.Time = configured.Time
+	}
+	if configured.Threads > limits.Threads {
+		limits.Threads = configured.Threads
+	}
+	return limits
+}
+
+func (h *Argon2id) ID() string {
+	return "argon2id"
+}
+
+func (h *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2id) Verify(encoded, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (h *Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Memory || params.Time != h.Time || params.Threads != h.Threads ||
+		uint32(len(salt)) != h.SaltLen || uint32(len(key)) != h.KeyLen
+}
+
+func decodeArgon2id(encoded string) (*Argon2id, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("unsupported argon2id version")
+	}
+
+	params := &Argon2id{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	// The parameters come from the stored hash, so they are bounded before
+	// Verify spends memory and time on them
+	limits := argon2Limits()
+	if params.Memory == 0 || params.Time == 0 || params.Threads == 0 ||
+		params.Memory > limits.Memory || params.Time > limits.Time || params.Threads > limits.Threads {
+		return nil, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(salt) == 0 || len(key) == 0 || uint32(len(key)) > limits.KeyLen {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,36 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt keeps the modular crypt format bcrypt has always used ($2a$...)
+type Bcrypt struct {
+	Cost int
+}
+
+func NewBcrypt() *Bcrypt {
+	return &Bcrypt{Cost: bcrypt.DefaultCost}
+}
+
+func (h *Bcrypt) ID() string {
+	return "2a"
+}
+
+func (h *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *Bcrypt) Verify(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (h *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
@@ -0,0 +1,104 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrMismatch = errors.New("password does not match")
+
+// Hasher hashes passwords into self-describing strings, so hashes from
+// other algorithms or older parameters can still be verified and upgraded.
+type Hasher interface {
+	// ID is the algorithm identifier used as the hash prefix
+	ID() string
+	Hash(password string) (string, error)
+	// Verify returns ErrMismatch when the password is wrong
+	Verify(encoded, password string) error
+	// NeedsRehash reports whether encoded was produced with other parameters
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	defaultHasher   Hasher
+	defaultHasherMu sync.Mutex
+)
+
+// SetDefault registers the hasher new hashes are created with
+func SetDefault(h Hasher) {
+	defaultHasherMu.Lock()
+	defer defaultHasherMu.Unlock()
+	defaultHasher = h
+}
+
+func Default() Hasher {
+	defaultHasherMu.Lock()
+	defer defaultHasherMu.Unlock()
+	if defaultHasher == nil {
+		defaultHasher = FromEnv()
+	}
+	return defaultHasher
+}
+
+// FromEnv reads PASSWORD_HASHER ("argon2id", the default, or "bcrypt") and
+// its parameters ARGON2_MEMORY (KiB), ARGON2_TIME, ARGON2_THREADS and
+// BCRYPT_COST.
+func FromEnv() Hasher {
+	switch strings.ToLower(os.Getenv("PASSWORD_HASHER")) {
+	case "bcrypt":
+		h := NewBcrypt()
+		h.Cost = envInt("BCRYPT_COST", h.Cost)
+		return h
+	default:
+		h := NewArgon2id()
+		h.Memory = uint32(envInt("ARGON2_MEMORY", int(h.Memory)))
+		h.Time = uint32(envInt("ARGON2_TIME", int(h.Time)))
+		h.Threads = uint8(envInt("ARGON2_THREADS", int(h.Threads)))
+		return h
+	}
+}
+
+// hasherFor picks the hasher able to verify encoded
+func hasherFor(encoded string) (Hasher, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return NewArgon2id(), nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return NewBcrypt(), nil
+	}
+	return nil, errors.New("unknown password hash format")
+}
+
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify checks the password against a hash from any supported algorithm
+func Verify(encoded, password string) error {
+	h, err := hasherFor(encoded)
+	if err != nil {
+		return err
+	}
+	return h.Verify(encoded, password)
+}
+
+// NeedsRehash reports whether encoded should be replaced by a hash from the
+// default hasher, because of a different algorithm or parameters.
+func NeedsRehash(encoded string) bool {
+	current, err := hasherFor(encoded)
+	if err != nil || current.ID() != Default().ID() {
+		return true
+	}
+	return Default().NeedsRehash(encoded)
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
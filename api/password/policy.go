@@ -0,0 +1,171 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Policy describes what new passwords must look like
+type Policy struct {
+	MinLength     int
+	MaxBytes      int // bcrypt ignores everything past 72 bytes
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	History       int // number of previous passwords that cannot be reused
+	Breached      *BreachedList
+}
+
+// PolicyError lists every rule a password broke
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+var (
+	currentPolicy   *Policy
+	currentPolicyMu sync.Mutex
+)
+
+// SetPolicy replaces the policy read from the environment
+func SetPolicy(p *Policy) {
+	currentPolicyMu.Lock()
+	defer currentPolicyMu.Unlock()
+	currentPolicy = p
+}
+
+func CurrentPolicy() *Policy {
+	currentPolicyMu.Lock()
+	defer currentPolicyMu.Unlock()
+	if currentPolicy == nil {
+		currentPolicy = PolicyFromEnv()
+	}
+	return currentPolicy
+}
+
+// PolicyFromEnv reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_BYTES,
+// PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT,
+// PASSWORD_REQUIRE_SYMBOL, PASSWORD_HISTORY and PASSWORD_BREACHED_FILE.
+func PolicyFromEnv() *Policy {
+	p := &Policy{
+		MinLength:     envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxBytes:      envInt("PASSWORD_MAX_BYTES", 72),
+		RequireUpper:  envBool("PASSWORD_REQUIRE_UPPER"),
+		RequireLower:  envBool("PASSWORD_REQUIRE_LOWER"),
+		RequireDigit:  envBool("PASSWORD_REQUIRE_DIGIT"),
+		RequireSymbol: envBool("PASSWORD_REQUIRE_SYMBOL"),
+		History:       envInt("PASSWORD_HISTORY", 3),
+	}
+
+	if path := os.Getenv("PASSWORD_BREACHED_FILE"); path != "" {
+		list, err := LoadBreachedList(path)
+		if err != nil {
+			log.Printf("cannot load breached passwords from %s: %v", path, err)
+		} else {
+			p.Breached = list
+		}
+	}
+	return p
+}
+
+// Violations returns the rules the password breaks, reuse is checked by
+// the caller since it needs the stored hashes.
+func (p *Policy) Violations(plain string) []string {
+	violations := []string{}
+
+	if len([]rune(plain)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if p.MaxBytes > 0 && len(plain) > p.MaxBytes {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes", p.MaxBytes))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range plain {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.Breached != nil && p.Breached.Contains(plain) {
+		violations = append(violations, "appears in a known data breach")
+	}
+
+	return violations
+}
+
+// BreachedList holds the SHA-1 hashes of passwords known from data breaches
+type BreachedList struct {
+	hashes map[string]struct{}
+}
+
+// LoadBreachedList reads one uppercase or lowercase SHA-1 hex hash per line.
+// The "HASH:COUNT" lines of the Have I Been Pwned downloads are accepted.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &BreachedList{hashes: map[string]struct{}{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(line, ":")
+		list.hashes[strings.ToUpper(hash)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *BreachedList) Contains(plain string) bool {
+	sum := sha1.Sum([]byte(plain))
+	_, ok := l.hashes[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return ok
+}
+
+func (l *BreachedList) Len() int {
+	return len(l.hashes)
+}
+
+func envBool(key string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(key))
+	return v
+}
@@ -30,3 +30,18 @@ func ErrorResponse(w http.ResponseWriter, statusCode int, err error) {
 
 	JsonResponse(w, http.StatusBadRequest, nil)
 }
+
+// ValidationErrorResponse adds the messages for each invalid field
+func ValidationErrorResponse(w http.ResponseWriter, statusCode int, err error, fields map[string][]string) {
+	JsonResponse(
+		w,
+		statusCode,
+		struct {
+			Error  string              `json:"error"`
+			Fields map[string][]string `json:"fields"`
+		}{
+			Error:  err.Error(),
+			Fields: fields,
+		},
+	)
+}
@@ -2,7 +2,9 @@ package seed
 
 import (
 	"log"
+	"time"
 
+	"github.com/mvr-garcia/fullgo/api/auth"
 	"github.com/mvr-garcia/fullgo/api/models"
 	"gorm.io/gorm"
 )
@@ -12,6 +14,7 @@ var users = []models.User{
 		Nickname: "Steven victor",
 		Email:    "steven@gmail.com",
 		Password: "password",
+		Role:     auth.RoleAdmin,
 	},
 	{
 		Nickname: "Martin Luther",
@@ -25,11 +28,13 @@ var posts = []models.Post{
 		Title:    "Title 1",
 		Content:  "Hello world 1",
 		AuthorID: 1,
+		Status:   models.PostPublished,
 	},
 	{
 		Title:    "Title 2",
 		Content:  "Hello world 2",
 		AuthorID: 2,
+		Status:   models.PostPublished,
 	},
 }
 
@@ -37,15 +42,74 @@ func Load(db *gorm.DB) {
 
 	var err error
 
-	err = db.Migrator().DropTable(&models.Post{}, &models.User{})
+	err = db.Migrator().DropTable(
+		&models.Attachment{},
+		&models.PostSlug{},
+		&models.PostRevision{},
+		&models.Comment{},
+		"post_tags",
+		&models.Tag{},
+		&models.Category{},
+		&models.MagicLink{},
+		&models.UserSession{},
+		&models.ImpersonationSession{},
+		&models.PasswordHistory{},
+		&models.UserIdentity{},
+		&models.PersonalAccessToken{},
+		&models.LoginAttempt{},
+		&models.RecoveryCode{},
+		&models.PasswordReset{},
+		&models.RevokedToken{},
+		&models.RefreshToken{},
+		&models.Post{},
+		&models.User{},
+	)
 	if err != nil {
 		log.Fatalf("cannot drop table: %v", err)
 	}
-	err = db.AutoMigrate(&models.User{}, &models.Post{})
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.Post{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.PasswordReset{},
+		&models.RecoveryCode{},
+		&models.LoginAttempt{},
+		&models.PersonalAccessToken{},
+		&models.UserIdentity{},
+		&models.PasswordHistory{},
+		&models.ImpersonationSession{},
+		&models.UserSession{},
+		&models.MagicLink{},
+		&models.Category{},
+		&models.Tag{},
+		&models.Comment{},
+		&models.PostRevision{},
+		&models.PostSlug{},
+		&models.Attachment{},
+	)
 	if err != nil {
 		log.Fatalf("cannot migrate table: %v", err)
 	}
 
+	err = models.MigratePostSearch(db)
+	if err != nil {
+		log.Fatalf("cannot migrate post search: %v", err)
+	}
+
+	// Seeded accounts are usable right away
+	now := time.Now()
+	for i := range users {
+		users[i].EmailVerifiedAt = &now
+		err = users[i].HashPassword()
+		if err != nil {
+			log.Fatalf("cannot hash seed password: %v", err)
+		}
+	}
+	for i := range posts {
+		posts[i].PublishedAt = &now
+	}
+
 	err = db.Create(&users).Error
 	if err != nil {
 		log.Fatalf("cannot seed users table: %v", err)
@@ -55,4 +119,9 @@ func Load(db *gorm.DB) {
 	if err != nil {
 		log.Fatalf("cannot seed posts table: %v", err)
 	}
+
+	err = models.BackfillPostSlugs(db)
+	if err != nil {
+		log.Fatalf("cannot seed post slugs: %v", err)
+	}
 }
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// maxPresignExpiry is the longest a presigned S3 URL may be valid
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// S3 keeps the files in a bucket of an S3 compatible object store, AWS or
+// self hosted ones like MinIO. Requests are signed with AWS Signature
+// Version 4.
+type S3 struct {
+	// Endpoint is the scheme and host, like https://s3.amazonaws.com or
+	// http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path instead of the host name, which
+	// self hosted stores usually need
+	PathStyle bool
+	Client    *http.Client
+}
+
+func (s *S3) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u, nil
+}
+
+func (s *S3) Put(key string, body io.Reader, size int64, contentType string) error {
+	resp, err := s.do(http.MethodPut, key, body, size, map[string]string{"content-type": contentType})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// SignedURL presigns a GET of the object, for at most a week
+func (s *S3) SignedURL(key string, expires time.Time) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	ttl := expires.Sub(now)
+	if ttl > maxPresignExpiry {
+		ttl = maxPresignExpiry
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+s.credentialScope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	signature := s.signature(http.MethodGet, u.EscapedPath(), query, map[string]string{"host": u.Host}, unsignedPayload, now)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// do sends a request signed in the Authorization header. The payload is
+// not hashed, which S3 allows for every request.
+func (s *S3) do(method, key string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	now := time.Now().UTC()
+	signed := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           now.Format("20060102T150405Z"),
+	}
+	for name, value := range headers {
+		signed[name] = value
+	}
+	for name, value := range signed {
+		if name != "host" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	signature := s.signature(method, u.EscapedPath(), url.Values{}, signed, unsignedPayload, now)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, s.credentialScope(now), signedHeaderNames(signed), signature))
+
+	return s.client().Do(req)
+}
+
+func (s *S3) credentialScope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.region() + "/s3/aws4_request"
+}
+
+// signature is the Signature Version 4 of a request, headers holding the
+// signed ones with lowercase names
+func (s *S3) signature(method, path string, query url.Values, headers map[string]string, payloadHash string, t time.Time) string {
+	names := strings.Split(signedHeaderNames(headers), ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		awsEscape(path, true),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaderNames(headers),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format("20060102T150405Z"),
+		s.credentialScope(t),
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func signedHeaderNames(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ";")
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// slashes when keepSlash. An already escaped path is decoded first.
+func awsEscape(s string, keepSlash bool) string {
+	if keepSlash {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+	}
+
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
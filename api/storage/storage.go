@@ -0,0 +1,52 @@
+// Package storage keeps uploaded files in a pluggable object store.
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage puts and serves objects by key. Keys are slash separated paths
+// like attachments/1/abc.png.
+type Storage interface {
+	Put(key string, body io.Reader, size int64, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// SignedURL is where the object can be downloaded without credentials
+	// until expires
+	SignedURL(key string, expires time.Time) (string, error)
+}
+
+// FromEnv picks the storage from STORAGE: "s3" or "local" (default)
+func FromEnv() Storage {
+	switch strings.ToLower(os.Getenv("STORAGE")) {
+	case "s3":
+		return &S3{
+			Endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: os.Getenv("S3_FORCE_PATH_STYLE") == "true",
+		}
+	default:
+		dir := os.Getenv("STORAGE_PATH")
+		if dir == "" {
+			dir = "uploads"
+		}
+		baseURL := strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080"
+		}
+		secret := os.Getenv("STORAGE_URL_SECRET")
+		if secret == "" {
+			secret = os.Getenv("API_SECRET")
+		}
+		return &Local{Dir: dir, BaseURL: baseURL + LocalPrefix, Secret: []byte(secret)}
+	}
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// maxDiffEdits bounds the work of a diff, texts further apart than that are
+// shown as replaced as a whole
+const maxDiffEdits = 2000
+
+// DiffOp is a run of text kept, inserted or deleted
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// diff returns the edit script turning a into b, one op per element, using
+// Myers' algorithm
+func diff(a, b []string) []DiffOp {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+
+	// v[off+k] is the furthest x reached on diagonal k, trace keeps v as it
+	// was before each step for the way back
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	trace := [][]int{}
+	steps := -1
+
+search:
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			x := v[off+k-1] + 1
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				steps = d
+				break search
+			}
+		}
+	}
+
+	if steps < 0 {
+		ops := []DiffOp{}
+		for _, s := range a {
+			ops = append(ops, DiffOp{Op: DiffDelete, Text: s})
+		}
+		for _, s := range b {
+			ops = append(ops, DiffOp{Op: DiffInsert, Text: s})
+		}
+		return ops
+	}
+
+	reversed := []DiffOp{}
+	x, y := n, m
+	for d := steps; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffOp{Op: DiffEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			reversed = append(reversed, DiffOp{Op: DiffInsert, Text: b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, DiffOp{Op: DiffDelete, Text: a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, DiffOp{Op: DiffEqual, Text: a[x-1]})
+		x--
+		y--
+	}
+
+	ops := make([]DiffOp, len(reversed))
+	for i, op := range reversed {
+		ops[len(reversed)-1-i] = op
+	}
+	return ops
+}
+
+// WordDiff compares two texts word by word, runs of the same op are merged
+func WordDiff(a, b string) []DiffOp {
+	merged := []DiffOp{}
+	for _, op := range diff(splitWords(a), splitWords(b)) {
+		if last := len(merged) - 1; last >= 0 && merged[last].Op == op.Op {
+			merged[last].Text += op.Text
+			continue
+		}
+		merged = append(merged, op)
+	}
+	return merged
+}
+
+// splitWords cuts s into words and the spaces between them, so that joining
+// the parts gives s back
+func splitWords(s string) []string {
+	parts := []string{}
+	start := 0
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != unicode.IsSpace(rune(s[start])) {
+			parts = append(parts, s[start:i])
+			start = i
+		}
+	}
+	if start < len(s) {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+// UnifiedDiff compares two texts line by line in the unified format, with
+// context lines around each change. Equal texts give an empty diff.
+func UnifiedDiff(fromName, toName, a, b string, context int) string {
+	ops := diff(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	changes := []int{}
+	for i, op := range ops {
+		if op.Op != DiffEqual {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Changes closer than twice the context share a hunk
+	for i := 0; i < len(changes); {
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*context+1 {
+			j++
+		}
+		start, end := changes[i]-context, changes[j]+context+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+		writeHunk(&out, ops, start, end)
+		i = j + 1
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []DiffOp, start, end int) {
+	aStart, bStart := 1, 1
+	for _, op := range ops[:start] {
+		if op.Op != DiffInsert {
+			aStart++
+		}
+		if op.Op != DiffDelete {
+			bStart++
+		}
+	}
+
+	aLen, bLen := 0, 0
+	for _, op := range ops[start:end] {
+		if op.Op != DiffInsert {
+			aLen++
+		}
+		if op.Op != DiffDelete {
+			bLen++
+		}
+	}
+
+	// An empty range starts at the line before it
+	if aLen == 0 {
+		aStart--
+	}
+	if bLen == 0 {
+		bStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+	for _, op := range ops[start:end] {
+		prefix := " "
+		switch op.Op {
+		case DiffInsert:
+			prefix = "+"
+		case DiffDelete:
+			prefix = "-"
+		}
+		out.WriteString(prefix + op.Text + "\n")
+	}
+}
@@ -16,6 +16,9 @@ func FormatError(err string) error {
 	if strings.Contains(err, "title") {
 		return errors.New("title already taken")
 	}
+	if strings.Contains(err, "slug") {
+		return errors.New("slug already taken")
+	}
 	if strings.Contains(err, "hashedPassword") {
 		return errors.New("incorrect password")
 	}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClientIP returns the address of the client. X-Forwarded-For is only
+// trusted when TRUST_PROXY is set, otherwise anyone could spoof it. Even
+// then only the entries appended by our proxies can be trusted, so the
+// address is taken TRUST_PROXY_HOPS (1 by default) entries from the right.
+func ClientIP(r *http.Request) string {
+
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			hops, err := strconv.Atoi(os.Getenv("TRUST_PROXY_HOPS"))
+			if err != nil || hops < 1 {
+				hops = 1
+			}
+			if hops > len(entries) {
+				hops = len(entries)
+			}
+			return strings.TrimSpace(entries[len(entries)-hops])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
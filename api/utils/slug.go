@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// transliterations spell letters of other alphabets in latin ones
+var transliterations = map[rune]string{
+	// Latin letters that do not decompose into a base letter and accents
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'þ': "th", 'ı': "i", 'ħ': "h",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'є': "ye", 'ж': "zh", 'з': "z",
+	'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p",
+	'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// transliterate spells r in ASCII when it is an accented or foreign letter
+// we know of
+func transliterate(r rune) (string, bool) {
+	if t, ok := transliterations[r]; ok {
+		return t, true
+	}
+
+	// é is e followed by an accent once decomposed
+	base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(r)))
+	if base < utf8.RuneSelf {
+		return string(base), true
+	}
+	t, ok := transliterations[base]
+	return t, ok
+}
+
+// Slugify lowercases s and joins its words with dashes. Accented, Cyrillic
+// and Greek letters are transliterated, letters and digits of other scripts
+// are kept as they are.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	write := func(r rune) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			return
+		}
+		dash = true
+	}
+
+	for _, r := range strings.ToLower(s) {
+		if t, ok := transliterate(r); ok {
+			for _, c := range t {
+				write(c)
+			}
+			continue
+		}
+		write(r)
+	}
+	return b.String()
+}